@@ -1,11 +1,13 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/kuso-kodo/kuso-NES/nes"
 	"github.com/kuso-kodo/kuso-NES/ui"
 	"log"
 	"os"
+	"strings"
 )
 
 const (
@@ -13,13 +15,51 @@ const (
 	EXEC_FAILED
 )
 
+// Sample rate used when running without an audio device.
+const headlessSampleRate = 44100
+
+var (
+	frames     = flag.Int("frames", 0, "run `n` frames without a window, then exit")
+	moviePath  = flag.String("movie", "", "replay an .fm2 movie `file` without a window")
+	aviPath    = flag.String("avi", "", "record video and audio to an AVI `file` (headless only)")
+	gifPath    = flag.String("gif", "", "write the last seconds of video to a GIF `file` (headless only)")
+	gifSeconds = flag.Float64("gif-seconds", 10, "length of the GIF clip in `seconds`")
+	gifHalf    = flag.Bool("gif-30fps", false, "keep every other frame in the GIF clip")
+	wavPath    = flag.String("wav", "", "record the APU output to a WAV `file` (headless only)")
+	wavRate    = flag.Int("wav-rate", 48000, "sample `rate` of the WAV file")
+	wavRaw     = flag.Bool("wav-raw", false, "record the WAV before the output filters")
+	wavStems   = flag.Bool("wav-stems", false, "also write one WAV file per APU channel")
+	vgmPath    = flag.String("vgm", "", "log the APU register writes to a VGM `file` (headless only)")
+	vgmLoop    = flag.Bool("vgm-loop", false, "detect the loop point of the VGM log")
+	dbPath     = flag.String("db", "", "load a ROM database in NstDatabase.xml format from `file`")
+	mappers    = flag.Bool("mappers", false, "list the supported mappers and exit")
+	track      = flag.Int("track", 0, "NSF `track` to play, 1 based (default: the file's starting track)")
+	fdsBIOS    = flag.String("fds-bios", nes.FDSBIOS, "Disk System BIOS `file`, else disksys.rom next to the image")
+	mute       = flag.String("mute", "", "comma separated `channels` to mute, like square1,vrc6-saw")
+)
+
 // Trying to connect UI with the f***ing PPU.
 func main() {
-	if len(os.Args) == 1 {
-		fmt.Println("Usage: kuso-NES <NES Rom Path>")
+	flag.Usage = func() {
+		fmt.Println("Usage: kuso-NES [options] <NES Rom Path>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *mappers {
+		listMappers()
+		return
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
 		os.Exit(EXEC_FAILED)
 	}
-	path, hastmp := nes.ReadFile(os.Args[1])
+	if *dbPath != "" {
+		if err := nes.LoadDatabase(*dbPath); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	nes.FDSBIOS = *fdsBIOS
+	path, hastmp := nes.ReadFile(flag.Arg(0))
 	log.Print(path)
 	NES, err := nes.NewNES(path)
 	if err != nil {
@@ -31,5 +71,110 @@ func main() {
 			log.Printf("Remove tmp dir %v failed: %v", nes.Tmpdir, err)
 		}
 	}
+	if *mute != "" {
+		for _, name := range strings.Split(*mute, ",") {
+			if !NES.APU.Mute(name, true) {
+				log.Fatalf("Unknown channel %q, expected one of: %s", name, strings.Join(NES.APU.Channels(), ", "))
+			}
+		}
+	}
+	if NES.NSF != nil {
+		if *track > 0 {
+			NES.SelectTrack(*track - 1)
+		}
+		log.Printf("NSF: %v", NES.NSF.TrackTitle(NES.Track()))
+	}
+	if *frames > 0 || *moviePath != "" || (NES.NSF != nil && (*wavPath != "" || *vgmPath != "")) {
+		if err := runHeadless(NES); err != nil {
+			log.Fatalln(err)
+		}
+		if err := NES.Save(); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
 	ui.Run(NES)
 }
+
+func listMappers() {
+	fmt.Println("Mapper  Submapper  PPU hooks  Boards")
+	for _, m := range nes.Mappers() {
+		sub := "any"
+		if m.Submapper != nes.AnySubmapper {
+			sub = fmt.Sprint(m.Submapper)
+		}
+		hooks := ""
+		if m.PPUHooks {
+			hooks = "yes"
+		}
+		fmt.Printf("%6d  %9s  %9s  %s\n", m.Number, sub, hooks, strings.Join(m.Boards, ", "))
+	}
+}
+
+// runHeadless runs the emulator as fast as possible for the requested number
+// of frames, until the movie ends, or for the length of the NSF track,
+// feeding the recorders given on the command line.
+func runHeadless(n *nes.NES) error {
+	var movie *nes.Movie
+	if *moviePath != "" {
+		m, err := nes.LoadMovie(*moviePath)
+		if err != nil {
+			return err
+		}
+		movie = m
+	}
+	count := *frames
+	if count == 0 && movie != nil {
+		count = movie.Frames()
+	}
+	if count == 0 && n.NSF != nil {
+		count = int(n.NSF.TrackDuration(n.Track()).Seconds() * nes.FrameRate)
+	}
+
+	n.SetAPUSRate(headlessSampleRate)
+	var recorders []nes.Recorder
+	// closeAll finishes the files of the recorders opened so far when a
+	// later one fails, so none is left with unpatched headers.
+	closeAll := func(err error) error {
+		for _, r := range recorders {
+			r.Close()
+		}
+		return err
+	}
+	if *aviPath != "" {
+		avi, err := nes.NewAVIRecorder(*aviPath, headlessSampleRate)
+		if err != nil {
+			return closeAll(err)
+		}
+		recorders = append(recorders, avi)
+	}
+	if *gifPath != "" {
+		recorders = append(recorders, nes.NewGIFRecorder(*gifPath, *gifSeconds, *gifHalf))
+	}
+	if *wavPath != "" {
+		wav, err := nes.NewWAVRecorder(*wavPath, *wavRate, !*wavRaw, *wavStems)
+		if err != nil {
+			return closeAll(err)
+		}
+		recorders = append(recorders, wav)
+	}
+	if *vgmPath != "" {
+		recorders = append(recorders, nes.NewVGMRecorder(*vgmPath, *vgmLoop))
+	}
+	for _, r := range recorders {
+		n.AddRecorder(r)
+	}
+	for i := 0; i < count; i++ {
+		if movie != nil {
+			movie.Step(n)
+		}
+		n.StepFrame()
+	}
+	var err error
+	for _, r := range recorders {
+		if e := n.RemoveRecorder(r); err == nil {
+			err = e
+		}
+	}
+	return err
+}
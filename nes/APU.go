@@ -351,6 +351,7 @@ func (n *Noise) output() byte {
 
 type DMC struct {
 	cpu            *CPU
+	apu            *APU
 	enabled        bool
 	val            byte
 	sampleAddress  uint16
@@ -405,6 +406,7 @@ func (d *DMC) rReader() {
 	if d.currentLength > 0 && d.bitCount == 0 {
 		d.cpu.stall += 4
 		d.sRegister = d.cpu.Read(d.currentAddress)
+		d.apu.recordDMC(d.currentAddress, d.sRegister)
 		d.bitCount = 8
 		d.currentAddress++
 		if d.currentAddress == 0 {
@@ -479,6 +481,15 @@ func HPassFilter(sampleRate float32, cutoffFreq float32) Filter {
 
 type FilterChain []Filter
 
+// NewFilterChain returns the filters of the NES output stage at sampleRate.
+func NewFilterChain(sampleRate float32) FilterChain {
+	return FilterChain{
+		HPassFilter(sampleRate, 90),
+		HPassFilter(sampleRate, 440),
+		LPassFilter(sampleRate, 14000),
+	}
+}
+
 func (fc FilterChain) Run(x float32) float32 {
 	if fc != nil {
 		for i := range fc {
@@ -488,10 +499,36 @@ func (fc FilterChain) Run(x float32) float32 {
 	return x
 }
 
+// ExpansionAudio is sound hardware on the cartridge. The mapper clocks it in
+// Tick, and the APU adds Output, on the scale of its own output, to the mix.
+type ExpansionAudio interface {
+	Output() float32
+}
+
+// ExpansionChannels is ExpansionAudio whose channels can be muted one by one.
+type ExpansionChannels interface {
+	ExpansionAudio
+	ChannelNames() []string
+	Mute(channel int, muted bool)
+}
+
+// APUChannels names the channels passed to a ChannelRecorder.
+var APUChannels = []string{"square1", "square2", "triangle", "noise", "dmc"}
+
+// apuTap samples the APU for a ChannelRecorder.
+type apuTap struct {
+	recorder ChannelRecorder
+	period   float64 // CPU cycles per sample
+	stems    []float32
+}
+
 type APU struct {
 	nes        *NES
 	channel    chan float32
 	sampleRate float64
+	taps       []*apuTap
+	expansion  ExpansionAudio
+	muted      [5]bool // in the order of APUChannels
 	square1    Square
 	square2    Square
 	triangle   Triangle
@@ -510,7 +547,10 @@ func NewAPU(nes *NES) *APU {
 	apu.noise.sRegister = 1
 	apu.square1.channel = 1
 	apu.square2.channel = 2
-	apu.dmc.cpu = nes.CPU
+	apu.dmc.apu = &apu
+	if e, ok := nes.Mapper.(ExpansionAudio); ok {
+		apu.expansion = e
+	}
 	return &apu
 }
 
@@ -529,24 +569,99 @@ func (a *APU) Run() {
 	if s1 != s2 {
 		a.sendSample()
 	}
+	for _, t := range a.taps {
+		s1 := int(float64(cycle1) / t.period)
+		s2 := int(float64(cycle2) / t.period)
+		if s1 != s2 {
+			a.sendTap(t)
+		}
+	}
+}
+
+func (a *APU) addTap(r ChannelRecorder) {
+	t := apuTap{recorder: r, period: CPUFrequency / r.SampleRate()}
+	t.stems = make([]float32, len(APUChannels))
+	a.taps = append(a.taps, &t)
+}
+
+func (a *APU) removeTap(r ChannelRecorder) {
+	for i, t := range a.taps {
+		if t.recorder == r {
+			a.taps = append(a.taps[:i], a.taps[i+1:]...)
+			return
+		}
+	}
+}
+
+func (a *APU) sendTap(t *apuTap) {
+	p1 := a.square1.output()
+	p2 := a.square2.output()
+	tr := a.triangle.output()
+	n := a.noise.output()
+	d := a.dmc.output()
+	t.stems[0] = pulseTable[p1]
+	t.stems[1] = pulseTable[p2]
+	t.stems[2] = tndTable[3*tr]
+	t.stems[3] = tndTable[2*n]
+	t.stems[4] = tndTable[d]
+	t.recorder.Channels(a.output(), t.stems)
 }
 
 func (a *APU) sendSample() {
 	output := a.fChain.Run(a.output())
+	a.nes.recordSample(output)
 	select {
 	case a.channel <- output:
 	default:
 	}
 }
 
+// Channels lists the channels that can be muted: the APU's and those of the
+// expansion audio of the cartridge.
+func (a *APU) Channels() []string {
+	names := append([]string{}, APUChannels...)
+	if e, ok := a.expansion.(ExpansionChannels); ok {
+		names = append(names, e.ChannelNames()...)
+	}
+	return names
+}
+
+// Mute silences or restores a channel in the mix. It reports false for an
+// unknown channel name.
+func (a *APU) Mute(name string, muted bool) bool {
+	for i, n := range APUChannels {
+		if n == name {
+			a.muted[i] = muted
+			return true
+		}
+	}
+	if e, ok := a.expansion.(ExpansionChannels); ok {
+		for i, n := range e.ChannelNames() {
+			if n == name {
+				e.Mute(i, muted)
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (a *APU) output() float32 {
 	p1 := a.square1.output()
 	p2 := a.square2.output()
 	t := a.triangle.output()
 	n := a.noise.output()
 	d := a.dmc.output()
+	for i, v := range []*byte{&p1, &p2, &t, &n, &d} {
+		if a.muted[i] {
+			*v = 0
+		}
+	}
 	pulseOut := pulseTable[p1+p2]
 	tndOut := tndTable[3*t+2*n+d]
+	if a.expansion != nil {
+		return pulseOut + tndOut + a.expansion.Output()
+	}
 	return pulseOut + tndOut
 }
 
@@ -615,6 +730,14 @@ func (a *APU) fireIRQ() {
 	}
 }
 
+func (a *APU) recordDMC(address uint16, val byte) {
+	for _, r := range a.nes.recorders {
+		if r, ok := r.(RegisterRecorder); ok {
+			r.DMCRead(a.cycle, address, val)
+		}
+	}
+}
+
 func (a *APU) ReadRegister(address uint16) byte {
 	switch address {
 	case 0x4015:
@@ -624,6 +747,11 @@ func (a *APU) ReadRegister(address uint16) byte {
 }
 
 func (a *APU) WriteRegister(address uint16, val byte) {
+	for _, r := range a.nes.recorders {
+		if r, ok := r.(RegisterRecorder); ok {
+			r.Register(a.cycle, address, val)
+		}
+	}
 	switch address {
 	case 0x4000:
 		a.square1.wCtrl(val)
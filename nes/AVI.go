@@ -0,0 +1,356 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"math"
+	"os"
+)
+
+// AVI recorder.
+// Video is stored as 8-bit RLE (msrle) against the NES palette, audio as 16-bit
+// mono PCM. Every PPU frame becomes one video chunk followed by one audio chunk
+// holding exactly the samples the APU produced during that frame, so the two
+// streams never drift apart.
+// Ref: https://docs.microsoft.com/en-us/windows/win32/directshow/avi-riff-file-reference
+
+const (
+	aviFrameRate  = CPUFrequency * 3 * 2 // PPU dots per second, doubled
+	aviFrameScale = 341*262*2 - 1        // 89341.5 dots per NTSC frame, doubled
+	aviHasIndex   = 0x10
+	aviKeyFrame   = 0x10
+	aviRLE8       = 1
+)
+
+type aviMainHeader struct {
+	MicroSecPerFrame    uint32
+	MaxBytesPerSec      uint32
+	PaddingGranularity  uint32
+	Flags               uint32
+	TotalFrames         uint32
+	InitialFrames       uint32
+	Streams             uint32
+	SuggestedBufferSize uint32
+	Width               uint32
+	Height              uint32
+	_                   [4]uint32
+}
+
+type aviStreamHeader struct {
+	Type                [4]byte
+	Handler             [4]byte
+	Flags               uint32
+	Priority            uint16
+	Language            uint16
+	InitialFrames       uint32
+	Scale               uint32
+	Rate                uint32
+	Start               uint32
+	Length              uint32
+	SuggestedBufferSize uint32
+	Quality             int32
+	SampleSize          uint32
+	Frame               [4]int16
+}
+
+type aviBitmapInfo struct {
+	Size          uint32
+	Width         int32
+	Height        int32
+	Planes        uint16
+	BitCount      uint16
+	Compression   uint32
+	SizeImage     uint32
+	XPelsPerMeter int32
+	YPelsPerMeter int32
+	ClrUsed       uint32
+	ClrImportant  uint32
+	Colors        [256][4]byte
+}
+
+type aviWaveFormat struct {
+	FormatTag      uint16
+	Channels       uint16
+	SamplesPerSec  uint32
+	AvgBytesPerSec uint32
+	BlockAlign     uint16
+	BitsPerSample  uint16
+	Size           uint16
+}
+
+type aviIndexEntry struct {
+	ID     [4]byte
+	Flags  uint32
+	Offset uint32
+	Size   uint32
+}
+
+type AVIRecorder struct {
+	file       *os.File
+	width      int
+	height     int
+	sampleRate int
+	mainHeader aviMainHeader
+	video      aviStreamHeader
+	audio      aviStreamHeader
+	offsets    map[string]int64 // file offsets of the fields patched on Close
+	movi       int64
+	size       int64
+	index      []aviIndexEntry
+	samples    []int16
+	rle        bytes.Buffer
+	pix        []byte // palette indices of frames given as RGBA
+	err        error  // first write error, reported by Close
+}
+
+// NewAVIRecorder creates path and writes the AVI headers. sampleRate must be
+// the rate the APU was configured with (see NES.SampleRate).
+func NewAVIRecorder(path string, sampleRate int) (*AVIRecorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := AVIRecorder{file: file, width: 256, height: 240, sampleRate: sampleRate}
+	r.offsets = make(map[string]int64)
+	if err := r.writeHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &r, nil
+}
+
+func fourCC(s string) [4]byte {
+	var id [4]byte
+	copy(id[:], s)
+	return id
+}
+
+func (r *AVIRecorder) write(data ...interface{}) error {
+	for _, d := range data {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, d)
+		n, err := r.file.Write(buf.Bytes())
+		r.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mark remembers where the next write lands so it can be patched later.
+func (r *AVIRecorder) mark(name string) {
+	r.offsets[name] = r.size
+}
+
+func (r *AVIRecorder) writeHeader() error {
+	frameSize := uint32(r.width * r.height * 2)
+	r.mainHeader = aviMainHeader{
+		MicroSecPerFrame:    uint32(1000000 * aviFrameScale / aviFrameRate),
+		MaxBytesPerSec:      frameSize*61 + uint32(r.sampleRate*2),
+		Flags:               aviHasIndex,
+		Streams:             2,
+		SuggestedBufferSize: frameSize,
+		Width:               uint32(r.width),
+		Height:              uint32(r.height),
+	}
+	r.video = aviStreamHeader{
+		Type:                fourCC("vids"),
+		Handler:             fourCC("mrle"),
+		Scale:               aviFrameScale,
+		Rate:                aviFrameRate,
+		SuggestedBufferSize: frameSize,
+		Quality:             -1,
+		Frame:               [4]int16{0, 0, int16(r.width), int16(r.height)},
+	}
+	r.audio = aviStreamHeader{
+		Type:                fourCC("auds"),
+		Scale:               2,
+		Rate:                uint32(r.sampleRate * 2),
+		SuggestedBufferSize: uint32(r.sampleRate / 30 * 2),
+		Quality:             -1,
+		SampleSize:          2,
+	}
+	bitmap := aviBitmapInfo{
+		Size:        40,
+		Width:       int32(r.width),
+		Height:      int32(r.height),
+		Planes:      1,
+		BitCount:    8,
+		Compression: aviRLE8,
+		SizeImage:   frameSize,
+		ClrUsed:     256,
+	}
+	for i, c := range Palette {
+		bitmap.Colors[i] = [4]byte{c.B, c.G, c.R, 0}
+	}
+	wave := aviWaveFormat{
+		FormatTag:      1,
+		Channels:       1,
+		SamplesPerSec:  uint32(r.sampleRate),
+		AvgBytesPerSec: uint32(r.sampleRate * 2),
+		BlockAlign:     2,
+		BitsPerSample:  16,
+	}
+
+	hSize := binary.Size(r.mainHeader)
+	sSize := binary.Size(r.video)
+	bSize := binary.Size(bitmap)
+	wSize := binary.Size(wave)
+	vList := 4 + 8 + sSize + 8 + bSize
+	aList := 4 + 8 + sSize + 8 + wSize
+	hdrl := 4 + 8 + hSize + 8 + vList + 8 + aList
+
+	r.mark("riff")
+	err := r.write(fourCC("RIFF"), uint32(0), fourCC("AVI "),
+		fourCC("LIST"), uint32(hdrl), fourCC("hdrl"),
+		fourCC("avih"), uint32(hSize))
+	if err != nil {
+		return err
+	}
+	r.mark("avih")
+	if err := r.write(r.mainHeader, fourCC("LIST"), uint32(vList), fourCC("strl"),
+		fourCC("strh"), uint32(sSize)); err != nil {
+		return err
+	}
+	r.mark("video")
+	if err := r.write(r.video, fourCC("strf"), uint32(bSize), bitmap,
+		fourCC("LIST"), uint32(aList), fourCC("strl"),
+		fourCC("strh"), uint32(sSize)); err != nil {
+		return err
+	}
+	r.mark("audio")
+	if err := r.write(r.audio, fourCC("strf"), uint32(wSize), wave); err != nil {
+		return err
+	}
+	r.mark("movi")
+	if err := r.write(fourCC("LIST"), uint32(0), fourCC("movi")); err != nil {
+		return err
+	}
+	r.movi = r.size - 4
+	return nil
+}
+
+func (r *AVIRecorder) writeChunk(id string, flags uint32, data []byte) error {
+	entry := aviIndexEntry{fourCC(id), flags, uint32(r.size - r.movi), uint32(len(data))}
+	r.index = append(r.index, entry)
+	if err := r.write(entry.ID, entry.Size, data); err != nil {
+		return err
+	}
+	if len(data)%2 == 1 {
+		return r.write(byte(0))
+	}
+	return nil
+}
+
+// encode compresses a frame of palette indices as RLE8, bottom row first.
+func (r *AVIRecorder) encode(pix []byte) []byte {
+	r.rle.Reset()
+	for y := r.height - 1; y >= 0; y-- {
+		row := pix[y*r.width : (y+1)*r.width]
+		for x := 0; x < r.width; {
+			c := row[x]
+			n := 1
+			for x+n < r.width && n < 255 && row[x+n] == c {
+				n++
+			}
+			r.rle.WriteByte(byte(n))
+			r.rle.WriteByte(c)
+			x += n
+		}
+		if y > 0 {
+			r.rle.Write([]byte{0, 0}) // end of line
+		} else {
+			r.rle.Write([]byte{0, 1}) // end of bitmap
+		}
+	}
+	return r.rle.Bytes()
+}
+
+// Frame converts a frame that did not come from the PPU, color by color.
+func (r *AVIRecorder) Frame(frame *image.RGBA) {
+	if r.file == nil {
+		return
+	}
+	if r.pix == nil {
+		r.pix = make([]byte, r.width*r.height)
+	}
+	for y := 0; y < r.height; y++ {
+		for x := 0; x < r.width; x++ {
+			r.pix[y*r.width+x] = PaletteIndex(frame.RGBAAt(x, y))
+		}
+	}
+	r.IndexedFrame(r.pix)
+}
+
+// IndexedFrame writes the palette entries the PPU rendered, and the samples
+// of the frame.
+func (r *AVIRecorder) IndexedFrame(pix []byte) {
+	if r.file == nil {
+		return
+	}
+	audio := make([]byte, len(r.samples)*2)
+	for i, s := range r.samples {
+		binary.LittleEndian.PutUint16(audio[i*2:], uint16(s))
+	}
+	if r.err == nil {
+		r.err = r.writeChunk("00dc", aviKeyFrame, r.encode(pix))
+	}
+	if r.err == nil {
+		r.err = r.writeChunk("01wb", 0, audio)
+	}
+	r.mainHeader.TotalFrames++
+	r.video.Length++
+	r.audio.Length += uint32(len(r.samples))
+	r.samples = r.samples[:0]
+}
+
+func (r *AVIRecorder) Sample(sample float32) {
+	if r.file == nil {
+		return
+	}
+	r.samples = append(r.samples, int16(math.Max(-1, math.Min(1, float64(sample)))*32767))
+}
+
+func (r *AVIRecorder) patch(name string, data ...interface{}) error {
+	if _, err := r.file.Seek(r.offsets[name], 0); err != nil {
+		return err
+	}
+	return r.write(data...)
+}
+
+// Close writes the index, fixes up the sizes and counters in the headers and
+// closes the file. Samples after the last frame are dropped.
+func (r *AVIRecorder) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	defer func() { r.file = nil }()
+	if r.err != nil {
+		r.file.Close()
+		return r.err
+	}
+	moviSize := uint32(r.size - r.movi)
+	if err := r.write(fourCC("idx1"), uint32(len(r.index)*16), r.index); err != nil {
+		r.file.Close()
+		return err
+	}
+	riffSize := uint32(r.size - 8)
+	for _, p := range []struct {
+		name string
+		data []interface{}
+	}{
+		{"riff", []interface{}{fourCC("RIFF"), riffSize}},
+		{"avih", []interface{}{r.mainHeader}},
+		{"video", []interface{}{r.video}},
+		{"audio", []interface{}{r.audio}},
+		{"movi", []interface{}{fourCC("LIST"), moviSize}},
+	} {
+		if err := r.patch(p.name, p.data...); err != nil {
+			r.file.Close()
+			return err
+		}
+	}
+	return r.file.Close()
+}
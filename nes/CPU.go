@@ -53,6 +53,7 @@ type CPU struct {
 	V      byte             // Overflow Flag
 	N      byte             // Negative Flag
 	inter  byte             // Interrupt type
+	lines  byte             // IRQ sources holding the IRQ line
 	stall  int              // Cycles to stall
 	ins    [256]func(*info) // Function table
 	Memory                  //Memory Interface
@@ -224,6 +225,10 @@ func (c *CPU) Run() int {
 
 	// Detect interrupts
 
+	if c.inter != interNMI && c.lines != 0 && c.I == 0 {
+		c.inter = interIRQ
+	}
+
 	switch c.inter {
 	case interIRQ:
 		c.irq()
@@ -308,6 +313,27 @@ func (c *CPU) tIRQ() {
 	}
 }
 
+// IRQ sources. Unlike tIRQ, which fires once, a source set with SetIRQ
+// keeps the line asserted until it is cleared, like the real IRQ line.
+const (
+	IRQMapper = 1 << iota
+	IRQExpansion
+)
+
+// SetIRQ asserts or releases the IRQ line for a source.
+func (c *CPU) SetIRQ(source byte, active bool) {
+	if active {
+		c.lines |= source
+	} else {
+		c.lines &^= source
+	}
+}
+
+// IRQ reports whether a source is asserting the IRQ line.
+func (c *CPU) IRQ(source byte) bool {
+	return c.lines&source != 0
+}
+
 // NMI Handler
 func (c *CPU) nmi() {
 	c.push16(c.PC)
@@ -520,6 +546,13 @@ func (c *CPU) and(info *info) {
 	c.setNZ(c.A)
 }
 
+// wModify writes the result of a read-modify-write instruction. The 6502
+// writes the unmodified value back first, one cycle before the result.
+func (c *CPU) wModify(address uint16, old, val byte) {
+	c.Write(address, old)
+	c.Write(address, val)
+}
+
 // ASL - Shift Left One Bit (Memory or Accumulator)
 // C <- [76543210] <- 0
 // N Z C I D V
@@ -530,10 +563,10 @@ func (c *CPU) asl(info *info) {
 		c.A = c.A << 1
 		c.setNZ(c.A)
 	} else { // Other Mode
-		val := c.Read(info.address)
-		c.C = (val >> 7) & 1
-		val = val << 1
-		c.Write(info.address, val)
+		old := c.Read(info.address)
+		c.C = (old >> 7) & 1
+		val := old << 1
+		c.wModify(info.address, old, val)
 		c.setNZ(val)
 	}
 }
@@ -722,8 +755,9 @@ func (c *CPU) cpy(info *info) {
 // N Z C I D V
 // + + - - - -
 func (c *CPU) dec(info *info) {
-	val := c.Read(info.address) - 1
-	c.Write(info.address, val)
+	old := c.Read(info.address)
+	val := old - 1
+	c.wModify(info.address, old, val)
 	c.setNZ(val)
 }
 
@@ -760,8 +794,9 @@ func (c *CPU) eor(info *info) {
 // N Z C I D V
 // + + - - - -
 func (c *CPU) inc(info *info) {
-	val := c.Read(info.address) + 1
-	c.Write(info.address, val)
+	old := c.Read(info.address)
+	val := old + 1
+	c.wModify(info.address, old, val)
 	c.setNZ(val)
 }
 
@@ -837,10 +872,10 @@ func (c *CPU) lsr(info *info) {
 		c.A >>= 1
 		c.setNZ(c.A)
 	} else {
-		value := c.Read(info.address)
-		c.C = value & 1
-		value >>= 1
-		c.Write(info.address, value)
+		old := c.Read(info.address)
+		c.C = old & 1
+		value := old >> 1
+		c.wModify(info.address, old, value)
 		c.setNZ(value)
 	}
 }
@@ -907,10 +942,10 @@ func (c *CPU) rol(info *info) {
 		c.setNZ(c.A)
 	} else {
 		cf := c.C
-		val := c.Read(info.address)
-		c.C = (val >> 7) & 1
-		val = (val << 1) | cf
-		c.Write(info.address, val)
+		old := c.Read(info.address)
+		c.C = (old >> 7) & 1
+		val := (old << 1) | cf
+		c.wModify(info.address, old, val)
 		c.setNZ(val)
 	}
 }
@@ -927,10 +962,10 @@ func (c *CPU) ror(info *info) {
 		c.setNZ(c.A)
 	} else {
 		cf := c.C
-		value := c.Read(info.address)
-		c.C = value & 1
-		value = (value >> 1) | (cf << 7)
-		c.Write(info.address, value)
+		old := c.Read(info.address)
+		c.C = old & 1
+		value := (old >> 1) | (cf << 7)
+		c.wModify(info.address, old, value)
 		c.setNZ(value)
 	}
 }
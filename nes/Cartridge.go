@@ -3,27 +3,37 @@ package nes
 import "log"
 
 type Cartridge struct {
-	PRG      []byte
-	CHR      []byte
-	SRAM     []byte
-	Mapper   byte
-	Mirror   byte
-	Battery  byte
-	prgBank  int
-	chrBank  int
-	prgBank1 int
-	prgBank2 int
-	chrBank1 int
+	PRG       []byte // ROM, only written by boards that flash themselves
+	CHR       []byte
+	CHRRAM    bool   // CHR is RAM, not ROM
+	SRAM      []byte // PRG-RAM, the PRGNVRAM battery-backed bytes first
+	PRGNVRAM  int
+	Mapper    uint16
+	Submapper byte
+	Mirror    byte
+	Battery   byte
+	Board     string // Board name from the ROM database
+	CRC       uint32 // CRC32 of PRG and CHR
+	CIRAM     []byte // 2KB nametable RAM of the console, wired by the cartridge
+	VRAM      []byte // extra 2KB nametable RAM of four-screen boards
+	nameTable [4][]byte
+	ntWrite   [4]bool
+	prgBank   int
+	chrBank   int
+	prgBank1  int
+	prgBank2  int
+	chrBank1  int
 }
 
-func NewCartridge(prg, chr []byte, mapper, mirror, battery byte) *Cartridge {
+func NewCartridge(prg, chr []byte, mapper uint16, mirror, battery byte) *Cartridge {
 	prgBank := len(prg) / 0x4000
 	chrBank := len(chr) / 0x2000
 	prgBank2 := prgBank - 1
 	sram := make([]byte, 0x2000)
 	cartridge := Cartridge{
-		prg, chr, sram, mapper, mirror, battery,
-		prgBank, chrBank, 0, prgBank2, 0}
+		PRG: prg, CHR: chr, SRAM: sram, Mapper: mapper, Battery: battery,
+		CIRAM: make([]byte, 0x0800), prgBank: prgBank, chrBank: chrBank, prgBank2: prgBank2}
+	cartridge.SetMirror(mirror)
 	return &cartridge
 }
 
@@ -40,8 +50,7 @@ func (c *Cartridge) Read(address uint16) byte {
 		idx := c.prgBank1*0x4000 + int(address-0x8000)
 		return c.PRG[idx]
 	case address >= 0x6000:
-		idx := int(address) - 0x6000
-		return c.SRAM[idx]
+		return c.ReadRAM(address)
 	default:
 		log.Fatalf("Illegal cartridge read at address: $%04X", address)
 	}
@@ -51,14 +60,89 @@ func (c *Cartridge) Read(address uint16) byte {
 func (c *Cartridge) Write(address uint16, val byte) {
 	switch {
 	case address < 0x2000:
-		idx := c.chrBank1*0x2000 + int(address)
-		c.CHR[idx] = val
+		c.WriteCHR(c.chrBank1*0x2000+int(address), val)
 	case address >= 0x8000:
 		c.prgBank1 = int(val)
 	case address >= 0x6000:
-		idx := int(address) - 0x6000
-		c.SRAM[idx] = val
+		c.WriteRAM(address, val)
 	default:
 		log.Fatalf("Illegal cartridge write at address: $%04X", address)
 	}
 }
+
+// SetRAM resizes the PRG-RAM to ram bytes plus nvram battery-backed bytes.
+func (c *Cartridge) SetRAM(ram, nvram int) {
+	c.SRAM = make([]byte, nvram+ram)
+	c.PRGNVRAM = nvram
+	if nvram > 0 {
+		c.Battery = 1
+	}
+}
+
+// ReadRAM reads the PRG-RAM at $6000-$7FFF, mirrored if it is smaller than
+// 8KB. Without PRG-RAM nothing answers and it reads 0.
+func (c *Cartridge) ReadRAM(address uint16) byte {
+	if len(c.SRAM) == 0 {
+		return 0
+	}
+	return c.SRAM[int(address-0x6000)%len(c.SRAM)]
+}
+
+func (c *Cartridge) WriteRAM(address uint16, val byte) {
+	if len(c.SRAM) != 0 {
+		c.SRAM[int(address-0x6000)%len(c.SRAM)] = val
+	}
+}
+
+// WriteCHR writes CHR at index, unless it is ROM.
+func (c *Cartridge) WriteCHR(index int, val byte) {
+	if c.CHRRAM {
+		c.CHR[index%len(c.CHR)] = val
+	}
+}
+
+// Tick does nothing. Mappers counting CPU cycles override it.
+func (c *Cartridge) Tick() {
+}
+
+// PPUAddress does nothing. Mappers watching the PPU bus override it.
+func (c *Cartridge) PPUAddress(address uint16) {
+}
+
+// Nametables
+// $2000-$2FFF is four 1KB slots, mirrored up to $3EFF. Each slot points at a
+// page of CIRAM, of the four-screen VRAM, of CHR or of mapper RAM.
+
+// SetMirror maps the slots to CIRAM, or to CIRAM and VRAM for MirrorFour.
+func (c *Cartridge) SetMirror(mode byte) {
+	c.Mirror = mode
+	if mode == MirrorFour && c.VRAM == nil {
+		c.VRAM = make([]byte, 0x0800)
+	}
+	for slot, page := range MirrorLookup[mode] {
+		if page < 2 {
+			c.MapNameTable(slot, c.CIRAM[page*0x0400:], true)
+		} else {
+			c.MapNameTable(slot, c.VRAM[(page-2)*0x0400:], true)
+		}
+	}
+}
+
+// MapNameTable points slot 0-3 at the first 1KB of mem. Writes to a slot
+// that is not writable, like CHR-ROM, are dropped.
+func (c *Cartridge) MapNameTable(slot int, mem []byte, writable bool) {
+	c.nameTable[slot] = mem[:0x0400]
+	c.ntWrite[slot] = writable
+}
+
+func (c *Cartridge) ReadNameTable(address uint16) byte {
+	address = (address - 0x2000) % 0x1000
+	return c.nameTable[address/0x0400][address%0x0400]
+}
+
+func (c *Cartridge) WriteNameTable(address uint16, val byte) {
+	address = (address - 0x2000) % 0x1000
+	if slot := address / 0x0400; c.ntWrite[slot] {
+		c.nameTable[slot][address%0x0400] = val
+	}
+}
@@ -0,0 +1,251 @@
+package nes
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ROM database.
+// Headers of old iNES dumps are often wrong or incomplete, so a cartridge can
+// be looked up by the CRC32 of its PRG and CHR data in a database in the
+// NstDatabase.xml format of Nestopia. Without a database the board names are
+// guessed from the mapper number.
+
+type GameInfo struct {
+	Board     string // e.g. "NES-SNROM"
+	Mapper    int    // -1 if unknown
+	Submapper int    // -1 if unknown
+	PRGROM    int    // sizes in bytes
+	CHRROM    int
+	PRGRAM    int // without battery
+	PRGNVRAM  int // with battery
+	CHRRAM    int
+	Chips     []string
+}
+
+var database = make(map[uint32]*GameInfo)
+
+type nstSize struct {
+	Size    string `xml:"size,attr"`
+	Battery string `xml:"battery,attr"`
+}
+
+type nstBoard struct {
+	Type   string    `xml:"type,attr"`
+	Mapper string    `xml:"mapper,attr"`
+	PRG    []nstSize `xml:"prg"`
+	CHR    []nstSize `xml:"chr"`
+	WRAM   []nstSize `xml:"wram"`
+	VRAM   []nstSize `xml:"vram"`
+	Chips  []struct {
+		Type string    `xml:"type,attr"`
+		WRAM []nstSize `xml:"wram"`
+	} `xml:"chip"`
+}
+
+type nstDatabase struct {
+	Games []struct {
+		Cartridges []struct {
+			CRC   string   `xml:"crc,attr"`
+			Board nstBoard `xml:"board"`
+		} `xml:"cartridge"`
+	} `xml:"game"`
+}
+
+// parseSize reads sizes like "8k".
+func parseSize(s string) int {
+	s = strings.ToLower(strings.TrimSpace(s))
+	scale := 1
+	if strings.HasSuffix(s, "k") {
+		scale = 1024
+		s = strings.TrimSuffix(s, "k")
+	}
+	n, _ := strconv.Atoi(s)
+	return n * scale
+}
+
+// LoadDatabase adds the games of an NstDatabase.xml file to the database.
+func LoadDatabase(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	db := nstDatabase{}
+	if err := xml.NewDecoder(file).Decode(&db); err != nil {
+		return fmt.Errorf("Error in reading ROM database: %v", err)
+	}
+	for _, g := range db.Games {
+		for _, c := range g.Cartridges {
+			crc, err := strconv.ParseUint(c.CRC, 16, 32)
+			if err != nil {
+				continue
+			}
+			b := c.Board
+			info := GameInfo{Board: b.Type, Mapper: -1, Submapper: -1}
+			if mapper, err := strconv.Atoi(b.Mapper); err == nil {
+				info.Mapper = mapper
+			}
+			for _, s := range b.PRG {
+				info.PRGROM += parseSize(s.Size)
+			}
+			for _, s := range b.CHR {
+				info.CHRROM += parseSize(s.Size)
+			}
+			for _, s := range b.VRAM {
+				info.CHRRAM += parseSize(s.Size)
+			}
+			wram := b.WRAM
+			for _, chip := range b.Chips {
+				info.Chips = append(info.Chips, chip.Type)
+				wram = append(wram, chip.WRAM...)
+			}
+			for _, s := range wram {
+				if s.Battery == "1" {
+					info.PRGNVRAM += parseSize(s.Size)
+				} else {
+					info.PRGRAM += parseSize(s.Size)
+				}
+			}
+			database[uint32(crc)] = &info
+		}
+	}
+	return nil
+}
+
+// LookupGame finds a cartridge by the CRC32 of its PRG and CHR data.
+func LookupGame(crc uint32) (*GameInfo, bool) {
+	info, ok := database[crc]
+	return info, ok
+}
+
+// Common boards of the iNES mapper numbers.
+// Ref: http://wiki.nesdev.com/w/index.php/Mapper
+var boardNames = map[int]string{
+	0:   "NROM",
+	1:   "SxROM (MMC1)",
+	2:   "UxROM",
+	3:   "CNROM",
+	4:   "TxROM (MMC3)",
+	5:   "ExROM (MMC5)",
+	7:   "AxROM",
+	9:   "PxROM (MMC2)",
+	10:  "FxROM (MMC4)",
+	11:  "Color Dreams",
+	13:  "CPROM",
+	16:  "Bandai FCG",
+	18:  "Jaleco SS88006",
+	19:  "Namco 163",
+	21:  "Konami VRC4a/VRC4c",
+	22:  "Konami VRC2a",
+	23:  "Konami VRC2b/VRC4e",
+	24:  "Konami VRC6a",
+	25:  "Konami VRC4b/VRC4d",
+	26:  "Konami VRC6b",
+	28:  "Action 53",
+	30:  "UNROM 512",
+	32:  "Irem G-101",
+	33:  "Taito TC0190",
+	34:  "BNROM/NINA-001",
+	48:  "Taito TC0690",
+	64:  "Tengen RAMBO-1",
+	65:  "Irem H3001",
+	66:  "GxROM/MHROM",
+	67:  "Sunsoft-3",
+	68:  "Sunsoft-4",
+	69:  "Sunsoft FME-7",
+	70:  "Bandai 74161/7432",
+	71:  "Camerica/Codemasters",
+	72:  "Jaleco JF-17",
+	73:  "Konami VRC3",
+	75:  "Konami VRC1",
+	76:  "Namco 3446",
+	79:  "NINA-03/NINA-06",
+	80:  "Taito X1-005",
+	82:  "Taito X1-017",
+	85:  "Konami VRC7",
+	86:  "Jaleco JF-13",
+	87:  "Jaleco J87",
+	88:  "Namco 3433",
+	89:  "Sunsoft-2 (Tenka no Goikenban)",
+	93:  "Sunsoft-2",
+	94:  "UN1ROM",
+	95:  "Namco 3425",
+	97:  "Irem TAM-S1",
+	105: "NES-EVENT",
+	111: "GTROM",
+	113: "NINA-03/NINA-06 (AVE)",
+	118: "TxSROM",
+	119: "TQROM",
+	140: "Jaleco JF-11/JF-14",
+	152: "Bandai 74161/7432 (one screen)",
+	153: "Bandai LZ93D50 with SRAM",
+	154: "Namco 3453",
+	155: "SxROM (MMC1A)",
+	157: "Bandai Datach",
+	159: "Bandai LZ93D50 with 24C01",
+	180: "UNROM (74HC08)",
+	184: "Sunsoft-1",
+	185: "CNROM with copy protection",
+	206: "Namco 108 (DxROM)",
+	210: "Namco 175/340",
+	228: "Action 52",
+	232: "Camerica Quattro",
+}
+
+// BoardName names the board of the cartridge, from the ROM database if it
+// knows the game, or else from the mapper number.
+func (c *Cartridge) BoardName() string {
+	if c.Board != "" {
+		return c.Board
+	}
+	if name, ok := boardNames[int(c.Mapper)]; ok {
+		return name
+	}
+	return "unknown board"
+}
+
+// Discrete boards whose register writes conflict with the ROM, by board name
+// without the "NES-"/"HVC-" prefix.
+// Ref: http://wiki.nesdev.com/w/index.php/Bus_conflict
+var busConflictBoards = map[string]bool{
+	"UNROM":  true,
+	"UOROM":  true,
+	"CNROM":  true,
+	"AMROM":  true,
+	"ANROM":  false,
+	"AOROM":  false,
+	"BNROM":  true,
+	"GNROM":  true,
+	"MHROM":  true,
+	"CPROM":  true,
+	"UN1ROM": true,
+}
+
+// BusConflicts reports whether a value written to the mapper is ANDed with
+// the ROM byte at the same address. The NES 2.0 submapper of UxROM, CNROM and
+// AxROM decides (1: no conflicts, 2: conflicts), then the board from the ROM
+// database, and byDefault for everything else.
+func (c *Cartridge) BusConflicts(byDefault bool) bool {
+	switch c.Mapper {
+	case 2, 3, 7:
+		switch c.Submapper {
+		case 1:
+			return false
+		case 2:
+			return true
+		}
+	}
+	board := c.Board
+	if i := strings.IndexByte(board, '-'); i >= 0 {
+		board = board[i+1:]
+	}
+	if conflicts, ok := busConflictBoards[board]; ok {
+		return conflicts
+	}
+	return byDefault
+}
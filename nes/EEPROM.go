@@ -0,0 +1,186 @@
+package nes
+
+import "fmt"
+
+// I2C serial EEPROMs of the Bandai boards
+// The game toggles the clock (SCL) and data (SDA) lines bit by bit. With SCL
+// high, SDA falling starts a command and rising stops it. Otherwise bits
+// are taken on the rise of SCL and the next one sent after its fall, and
+// each byte is acknowledged by pulling SDA low for a clock.
+// The 24C02 (256 bytes) is addressed as an I2C device, then a byte of word
+// address, then data, MSB first. The X24C01 (128 bytes) skips the device
+// byte: its 7-bit address and the read/write bit come first, LSB first.
+// Ref: http://wiki.nesdev.com/w/index.php/Bandai_FCG_board#Serial_EEPROM
+
+const (
+	eepromIdle = iota
+	eepromDevice
+	eepromAddress
+	eepromRead
+	eepromWrite
+	eepromSendAck // the EEPROM acknowledges a byte
+	eepromWaitAck // the game acknowledges a byte read
+)
+
+type eeprom struct {
+	data     []byte
+	x24c01   bool
+	mode     int
+	next     int // mode after the acknowledge
+	bits     int
+	shift    byte
+	address  int
+	scl, sda bool
+	output   bool // SDA driven by the EEPROM, high when released
+	written  bool
+}
+
+func newEEPROM(size int) *eeprom {
+	return &eeprom{data: make([]byte, size), x24c01: size == 0x80, output: true}
+}
+
+// receive takes a bit on the rise of SCL.
+func (e *eeprom) receive() {
+	if e.bits == 8 {
+		return
+	}
+	if e.x24c01 {
+		e.shift >>= 1
+		if e.sda {
+			e.shift |= 0x80
+		}
+	} else {
+		e.shift <<= 1
+		if e.sda {
+			e.shift |= 1
+		}
+	}
+	e.bits++
+}
+
+// send puts the next bit of the byte read on SDA.
+func (e *eeprom) send() {
+	if e.bits == 8 {
+		return
+	}
+	bit := uint(7 - e.bits)
+	if e.x24c01 {
+		bit = uint(e.bits)
+	}
+	e.output = e.shift>>bit&1 != 0
+	e.bits++
+}
+
+func (e *eeprom) acknowledge(next int) {
+	e.mode = eepromSendAck
+	e.next = next
+	e.bits = 0
+}
+
+// write sets SCL and SDA as the game drives them.
+func (e *eeprom) write(scl, sda bool) {
+	switch {
+	case e.scl && scl && e.sda && !sda: // start
+		e.mode = eepromDevice
+		if e.x24c01 {
+			e.mode = eepromAddress
+		}
+		e.bits = 0
+		e.output = true
+	case e.scl && scl && !e.sda && sda: // stop
+		e.mode = eepromIdle
+		e.output = true
+	case !e.scl && scl:
+		e.sda = sda
+		switch e.mode {
+		case eepromDevice, eepromAddress, eepromWrite:
+			e.receive()
+		case eepromRead:
+			e.send()
+		case eepromSendAck:
+			e.output = false
+		case eepromWaitAck:
+			if sda { // no acknowledge, the game is done reading
+				e.next = eepromIdle
+			}
+		}
+	case e.scl && !scl:
+		e.fall()
+	}
+	e.scl, e.sda = scl, sda
+}
+
+// fall moves on after a whole byte, on the fall of SCL.
+func (e *eeprom) fall() {
+	switch e.mode {
+	case eepromDevice:
+		if e.bits < 8 {
+			return
+		}
+		if e.shift&0xF0 != 0xA0 {
+			e.mode = eepromIdle
+		} else if e.shift&1 != 0 {
+			e.acknowledge(eepromRead)
+		} else {
+			e.acknowledge(eepromAddress)
+		}
+	case eepromAddress:
+		if e.bits < 8 {
+			return
+		}
+		if e.x24c01 {
+			e.address = int(e.shift & 0x7F)
+			if e.shift&0x80 != 0 {
+				e.acknowledge(eepromRead)
+			} else {
+				e.acknowledge(eepromWrite)
+			}
+		} else {
+			e.address = int(e.shift) % len(e.data)
+			e.acknowledge(eepromWrite)
+		}
+	case eepromWrite:
+		if e.bits < 8 {
+			return
+		}
+		e.data[e.address] = e.shift
+		e.written = true
+		e.address = (e.address + 1) % len(e.data)
+		e.acknowledge(eepromWrite)
+	case eepromRead:
+		if e.bits < 8 {
+			return
+		}
+		e.mode = eepromWaitAck
+		e.next = eepromRead
+		e.output = true
+		e.address = (e.address + 1) % len(e.data)
+	case eepromSendAck, eepromWaitAck:
+		e.mode = e.next
+		e.bits = 0
+		e.output = true
+		if e.mode == eepromRead {
+			e.shift = e.data[e.address]
+		}
+	}
+}
+
+// read is the SDA line as the EEPROM drives it.
+func (e *eeprom) read() bool {
+	return e.output
+}
+
+func (e *eeprom) save() []byte {
+	if !e.written {
+		return nil
+	}
+	return e.data
+}
+
+func (e *eeprom) load(data []byte) error {
+	if len(data) != len(e.data) {
+		return fmt.Errorf("EEPROM save is %d bytes, expected %d", len(data), len(e.data))
+	}
+	copy(e.data, data)
+	return nil
+}
@@ -0,0 +1,324 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Famicom Disk System images.
+// A .fds file holds each disk side, 65500 bytes, optionally behind a 16-byte
+// fwNES header. It only keeps the blocks of a side: the gaps and CRCs of the
+// real disk are added back when the image is loaded, so that the drive of
+// MapperFDS streams the side byte by byte as the RAM adapter would see it.
+// What games write to the disk is kept as an IPS patch of the image, next
+// to it, and applied again the next time the image is loaded.
+// Ref: http://wiki.nesdev.com/w/index.php/FDS_file_format
+// Ref: http://wiki.nesdev.com/w/index.php/FDS_disk_format
+
+const FDSMagicNumber = 0x1A534446    // "FDS^Z"
+const FDSRawMagicNumber = 0x494E2A01 // "\x01*NI", the disk info block of an image without header
+
+const (
+	fdsHeaderSize = 16
+	fdsSideSize   = 65500
+	fdsDiskSize   = 80000 // bytes of a side with the gaps, about 6.7s of reading
+	fdsLeadIn     = 28300 / 8
+	fdsGap        = 976 / 8
+)
+
+// FDSBIOS is the path of the 8KB Disk System BIOS. When it doesn't exist,
+// disksys.rom next to the image is tried.
+var FDSBIOS = "disksys.rom"
+
+type FDS struct {
+	Path     string
+	original []byte   // the file as it is on disk
+	header   []byte   // fwNES header, or nil
+	sides    [][]byte // the sides as loaded
+	disks    [][]byte // the sides as the drive reads them, with gaps and CRCs
+	written  []bool   // the drive wrote to the side
+	bios     []byte
+}
+
+// IsFDS reports whether the file at path is a disk image, with or without
+// header.
+func IsFDS(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(data) < 4 {
+		return false
+	}
+	magic := binary.LittleEndian.Uint32(data)
+	return magic == FDSMagicNumber || magic == FDSRawMagicNumber
+}
+
+func LoadFDS(path string) (*FDS, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fds := FDS{Path: path, original: data}
+	if patch, err := ioutil.ReadFile(fds.savePath()); err == nil {
+		if data, err = applyIPS(data, patch); err != nil {
+			return nil, fmt.Errorf("Error in applying %v: %v", fds.savePath(), err)
+		}
+	}
+	if len(data) >= 4 && binary.LittleEndian.Uint32(data) == FDSMagicNumber {
+		fds.header = data[:fdsHeaderSize]
+		data = data[fdsHeaderSize:]
+	}
+	if len(data) < fdsSideSize {
+		return nil, errors.New("File too short. Invalid FDS image.")
+	}
+	for i := 0; i+fdsSideSize <= len(data); i += fdsSideSize {
+		fds.sides = append(fds.sides, data[i:i+fdsSideSize])
+		fds.disks = append(fds.disks, packSide(data[i:i+fdsSideSize]))
+	}
+	fds.written = make([]bool, len(fds.sides))
+	if fds.bios, err = loadFDSBIOS(path); err != nil {
+		return nil, err
+	}
+	return &fds, nil
+}
+
+func loadFDSBIOS(image string) ([]byte, error) {
+	bios, err := ioutil.ReadFile(FDSBIOS)
+	if os.IsNotExist(err) {
+		bios, err = ioutil.ReadFile(filepath.Join(filepath.Dir(image), "disksys.rom"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error in reading the FDS BIOS: %v", err)
+	}
+	if len(bios) != 0x2000 {
+		return nil, fmt.Errorf("FDS BIOS is %d bytes, expected 8192", len(bios))
+	}
+	return bios, nil
+}
+
+// Sides is the number of disk sides in the image.
+func (f *FDS) Sides() int {
+	return len(f.disks)
+}
+
+// Cartridge is the RAM adapter: the BIOS as PRG, 32KB of PRG-RAM and 8KB of
+// CHR-RAM.
+func (f *FDS) Cartridge() *Cartridge {
+	c := NewCartridge(f.bios, make([]byte, 0x2000), 20, MirrorHorizontal, 0)
+	c.CHRRAM = true
+	c.Board = "FDS"
+	c.SetRAM(0x8000, 0)
+	return c
+}
+
+func (f *FDS) savePath() string {
+	return strings.TrimSuffix(f.Path, filepath.Ext(f.Path)) + ".ips"
+}
+
+// Save writes the changes made to the disks as an IPS patch of the image,
+// or removes the patch if the disks are the same as the image. Sides the
+// drive didn't write are saved as loaded.
+func (f *FDS) Save() error {
+	data := append([]byte{}, f.header...)
+	for i, disk := range f.disks {
+		if f.written[i] {
+			data = append(data, unpackSide(disk)...)
+		} else {
+			data = append(data, f.sides[i]...)
+		}
+	}
+	patch := diffIPS(f.original, data)
+	if patch == nil {
+		if err := os.Remove(f.savePath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(f.savePath(), patch, 0644)
+}
+
+// Sides on the disk start with a long gap. Each block is led by a gap and
+// the start mark $80, and followed by its CRC.
+func packSide(side []byte) []byte {
+	disk := make([]byte, fdsLeadIn, fdsDiskSize)
+	var fileSize int
+	for pos := 0; pos < len(side); {
+		n := fdsBlockSize(side[pos:], &fileSize)
+		if n == 0 {
+			break
+		}
+		disk = append(disk, 0x80)
+		disk = append(disk, side[pos:pos+n]...)
+		crc := fdsCRC(0, 0x80)
+		for _, b := range side[pos : pos+n] {
+			crc = fdsCRC(crc, b)
+		}
+		crc = fdsCRC(fdsCRC(crc, 0), 0)
+		disk = append(disk, byte(crc), byte(crc>>8))
+		disk = append(disk, make([]byte, fdsGap)...)
+		pos += n
+	}
+	if len(disk) < fdsDiskSize {
+		disk = append(disk, make([]byte, fdsDiskSize-len(disk))...)
+	}
+	return disk
+}
+
+// unpackSide takes the blocks out of a side as the drive sees it.
+func unpackSide(disk []byte) []byte {
+	side := make([]byte, 0, fdsSideSize)
+	var fileSize int
+	for pos := 0; ; {
+		for pos < len(disk) && disk[pos] == 0 {
+			pos++
+		}
+		if pos >= len(disk) || disk[pos] != 0x80 {
+			break
+		}
+		pos++
+		n := fdsBlockSize(disk[pos:], &fileSize)
+		if n == 0 || len(side)+n > fdsSideSize {
+			break
+		}
+		side = append(side, disk[pos:pos+n]...)
+		pos += n + 2
+	}
+	return append(side, make([]byte, fdsSideSize-len(side))...)
+}
+
+// fdsBlockSize is the size of the block at the start of data, by its type,
+// or 0 if there is no valid block. A file header block stores the size of
+// the data block following it in fileSize.
+func fdsBlockSize(data []byte, fileSize *int) int {
+	if len(data) == 0 {
+		return 0
+	}
+	var n int
+	switch data[0] {
+	case 1: // disk info
+		n = 56
+	case 2: // file amount
+		n = 2
+	case 3: // file header
+		n = 16
+		if len(data) >= n {
+			*fileSize = int(data[13]) | int(data[14])<<8
+		}
+	case 4: // file data
+		n = 1 + *fileSize
+	}
+	if n > len(data) {
+		return 0
+	}
+	return n
+}
+
+// fdsCRC adds a byte to the CRC of a block, as the RAM adapter computes it.
+func fdsCRC(crc uint16, val byte) uint16 {
+	for bit := uint(0); bit < 8; bit++ {
+		carry := crc & 1
+		crc >>= 1
+		if carry != 0 {
+			crc ^= 0x8408
+		}
+		if val>>bit&1 != 0 {
+			crc ^= 0x8000
+		}
+	}
+	return crc
+}
+
+// IPS patches
+// "PATCH", then records of a 3-byte offset, 2-byte size and the bytes, then
+// "EOF". All numbers are big-endian.
+
+const ipsEOF = 0x454F46
+
+func diffIPS(old, new []byte) []byte {
+	var out bytes.Buffer
+	for i := 0; i < len(new); {
+		if i < len(old) && old[i] == new[i] {
+			i++
+			continue
+		}
+		start := i
+		if start == ipsEOF { // would read as the end of the patch
+			start--
+		}
+		for i < len(new) && i-start < 0xFFFF && (i >= len(old) || old[i] != new[i]) {
+			i++
+		}
+		out.Write([]byte{byte(start >> 16), byte(start >> 8), byte(start), byte((i - start) >> 8), byte(i - start)})
+		out.Write(new[start:i])
+	}
+	if out.Len() == 0 {
+		return nil
+	}
+	return append(append([]byte("PATCH"), out.Bytes()...), "EOF"...)
+}
+
+func applyIPS(data, patch []byte) ([]byte, error) {
+	if !bytes.HasPrefix(patch, []byte("PATCH")) {
+		return nil, errors.New("Not an IPS patch.")
+	}
+	data = append([]byte{}, data...)
+	for pos := 5; ; {
+		if pos+3 > len(patch) {
+			return nil, errors.New("IPS patch is truncated.")
+		}
+		offset := int(patch[pos])<<16 | int(patch[pos+1])<<8 | int(patch[pos+2])
+		if offset == ipsEOF {
+			return data, nil
+		}
+		if pos+5 > len(patch) {
+			return nil, errors.New("IPS patch is truncated.")
+		}
+		size := int(patch[pos+3])<<8 | int(patch[pos+4])
+		pos += 5
+		var chunk []byte
+		if size == 0 { // run length encoded
+			if pos+3 > len(patch) {
+				return nil, errors.New("IPS patch is truncated.")
+			}
+			chunk = bytes.Repeat(patch[pos+2:pos+3], int(patch[pos])<<8|int(patch[pos+1]))
+			pos += 3
+		} else {
+			if pos+size > len(patch) {
+				return nil, errors.New("IPS patch is truncated.")
+			}
+			chunk = patch[pos : pos+size]
+			pos += size
+		}
+		if offset+len(chunk) > len(data) {
+			data = append(data, make([]byte, offset+len(chunk)-len(data))...)
+		}
+		copy(data[offset:], chunk)
+	}
+}
+
+// EjectDisk takes the disk out of the Disk System, or puts it back in.
+func (n *NES) EjectDisk() {
+	if m, ok := n.Mapper.(*MapperFDS); ok {
+		m.Eject()
+	}
+}
+
+// NextDiskSide puts the next disk side in the Disk System.
+func (n *NES) NextDiskSide() {
+	if m, ok := n.Mapper.(*MapperFDS); ok {
+		m.NextSide()
+	}
+}
+
+// DiskSide returns the disk side in the Disk System, 0 based, and whether it
+// is inserted.
+func (n *NES) DiskSide() (int, bool) {
+	if m, ok := n.Mapper.(*MapperFDS); ok {
+		return m.side, !m.ejected
+	}
+	return 0, false
+}
@@ -0,0 +1,232 @@
+package nes
+
+import "math"
+
+// FDS audio
+// One wavetable channel: 64 6-bit samples played at a 12-bit pitch, with
+// volume and modulation envelopes, and a modulator bending the pitch by a
+// table of 64 3-bit steps. The output goes through a low-pass filter of
+// about 2KHz on the RAM adapter.
+// Ref: http://wiki.nesdev.com/w/index.php/FDS_audio
+
+// FDSChannels names the FDS audio channel, for APU.Mute.
+var FDSChannels = []string{"fds"}
+
+// Master volume, $4089 bits 0-1, in 30ths.
+var fdsMasterVolumes = [4]int{30, 20, 15, 12}
+
+// Modulation table steps; 4 resets the counter.
+var fdsModSteps = [8]int{0, 1, 2, 4, 0, -4, -2, -1}
+
+// Low-pass filter coefficient for each CPU cycle.
+var fdsFilter = float32(1 - math.Exp(-2*math.Pi*2000/CPUFrequency))
+
+type fdsEnvelope struct {
+	speed    int
+	gain     int
+	increase bool
+	off      bool // the gain is set directly
+	timer    int
+}
+
+func (e *fdsEnvelope) write(val byte) {
+	e.off = val&0x80 != 0
+	e.increase = val&0x40 != 0
+	e.speed = int(val & 0x3F)
+	if e.off {
+		e.gain = e.speed
+	}
+	e.timer = 0
+}
+
+// tick runs one CPU cycle. The envelope steps every 8*(speed+1)*master
+// cycles.
+func (e *fdsEnvelope) tick(master int) {
+	if e.off || master == 0 {
+		return
+	}
+	e.timer++
+	if e.timer < 8*(e.speed+1)*master {
+		return
+	}
+	e.timer = 0
+	if e.increase && e.gain < 32 {
+		e.gain++
+	} else if !e.increase && e.gain > 0 {
+		e.gain--
+	}
+}
+
+type fdsAudio struct {
+	enabled   bool // $4023 bit 1
+	wave      [64]byte
+	waveWrite bool // $4089 bit 7 holds the wave and opens it to writes
+	master    int
+	pitch     int
+	waveHalt  bool
+	envHalt   bool
+	waveAcc   int
+	wavePos   int
+	volume    fdsEnvelope
+	mod       fdsEnvelope
+	modTable  [64]byte
+	modPos    int
+	modPitch  int
+	modHalt   bool
+	modAcc    int
+	counter   int // 7-bit signed
+	envSpeed  int // $408A
+	level     int // DAC output
+	filtered  float32
+	muted     bool
+}
+
+func newFDSAudio() fdsAudio {
+	return fdsAudio{envSpeed: 0xE8}
+}
+
+func (a *fdsAudio) read(address uint16) byte {
+	switch {
+	case address < 0x4080:
+		return a.wave[address-0x4040] | 0x40
+	case address == 0x4090:
+		return byte(a.volume.gain) | 0x40
+	case address == 0x4092:
+		return byte(a.mod.gain) | 0x40
+	}
+	return 0x40
+}
+
+func (a *fdsAudio) write(address uint16, val byte) {
+	if !a.enabled {
+		return
+	}
+	switch address {
+	case 0x4080:
+		a.volume.write(val)
+	case 0x4082:
+		a.pitch = a.pitch&0xF00 | int(val)
+	case 0x4083:
+		a.pitch = a.pitch&0x0FF | int(val&0x0F)<<8
+		a.waveHalt = val&0x80 != 0
+		a.envHalt = val&0x40 != 0
+		if a.waveHalt {
+			a.waveAcc = 0
+			a.wavePos = 0
+		}
+	case 0x4084:
+		a.mod.write(val)
+	case 0x4085:
+		a.counter = fdsSigned7(int(val))
+	case 0x4086:
+		a.modPitch = a.modPitch&0xF00 | int(val)
+	case 0x4087:
+		a.modPitch = a.modPitch&0x0FF | int(val&0x0F)<<8
+		a.modHalt = val&0x80 != 0
+		if a.modHalt {
+			a.modAcc = 0
+		}
+	case 0x4088:
+		// Each write fills two steps of the table, while the modulator is
+		// halted.
+		if a.modHalt {
+			a.modTable[a.modPos] = val & 7
+			a.modTable[(a.modPos+1)&0x3F] = val & 7
+			a.modPos = (a.modPos + 2) & 0x3F
+		}
+	case 0x4089:
+		a.waveWrite = val&0x80 != 0
+		a.master = int(val & 3)
+	case 0x408A:
+		a.envSpeed = int(val)
+	default:
+		if address >= 0x4040 && address < 0x4080 && a.waveWrite {
+			a.wave[address-0x4040] = val & 0x3F
+		}
+	}
+}
+
+// tick runs one CPU cycle.
+func (a *fdsAudio) tick() {
+	if !a.waveHalt && !a.envHalt {
+		a.volume.tick(a.envSpeed)
+		a.mod.tick(a.envSpeed)
+	}
+	if !a.modHalt && a.modPitch != 0 {
+		a.modAcc += a.modPitch
+		if a.modAcc >= 0x10000 {
+			a.modAcc -= 0x10000
+			a.stepModulator()
+		}
+	}
+	if !a.waveHalt && !a.waveWrite {
+		a.waveAcc += a.modulatedPitch()
+		for a.waveAcc >= 0x10000 {
+			a.waveAcc -= 0x10000
+			a.wavePos = (a.wavePos + 1) & 0x3F
+		}
+		gain := a.volume.gain
+		if gain > 32 {
+			gain = 32
+		}
+		a.level = int(a.wave[a.wavePos]) * gain * fdsMasterVolumes[a.master] / 30
+	}
+	a.filtered += (float32(a.level) - a.filtered) * fdsFilter
+}
+
+func (a *fdsAudio) stepModulator() {
+	step := a.modTable[a.modPos]
+	a.modPos = (a.modPos + 1) & 0x3F
+	if step == 4 {
+		a.counter = 0
+	} else {
+		a.counter = fdsSigned7(a.counter + fdsModSteps[step])
+	}
+}
+
+// fdsSigned7 wraps v to the 7-bit signed range of the modulator counter.
+func fdsSigned7(v int) int {
+	v &= 0x7F
+	if v >= 0x40 {
+		v -= 0x80
+	}
+	return v
+}
+
+// modulatedPitch bends the pitch by the modulator counter times its gain,
+// rounded the odd way the hardware does.
+func (a *fdsAudio) modulatedPitch() int {
+	temp := a.counter * a.mod.gain
+	remainder := temp & 0x0F
+	temp >>= 4
+	if remainder > 0 && temp&0x80 == 0 {
+		if a.counter < 0 {
+			temp--
+		} else {
+			temp += 2
+		}
+	}
+	if temp >= 192 {
+		temp -= 256
+	} else if temp < -64 {
+		temp += 256
+	}
+	temp *= a.pitch
+	remainder = temp & 0x3F
+	temp >>= 6
+	if remainder >= 32 {
+		temp++
+	}
+	if p := a.pitch + temp; p > 0 {
+		return p
+	}
+	return 0
+}
+
+// output is 0 to 1 at full volume.
+func (a *fdsAudio) output() float32 {
+	if a.muted {
+		return 0
+	}
+	return a.filtered / (63 * 32)
+}
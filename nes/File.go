@@ -24,7 +24,7 @@ func ReadFile(path string) (string, bool) {
 		log.Printf("Readfile : %v", err)
 	}
 	switch header {
-	case NESMagicNumber:
+	case NESMagicNumber, NSFMagicNumber, NSFeMagicNumber, FDSMagicNumber, FDSRawMagicNumber:
 		return path, false
 	case ZIPMagicNumber:
 		return Zip(path), true
@@ -0,0 +1,102 @@
+package nes
+
+import "fmt"
+
+// SST39SF0x0 flash
+// Homebrew boards keep PRG on a flash chip the game can write to, for saves.
+// Commands are written to chip addresses $5555 and $2AAA: $AA, $55 then
+// $A0 programs a byte, $80 and $AA, $55 again then $10 erases the chip or
+// $30 the 4KB sector written to, $90 shows the chip ID and $F0 goes back to
+// reading. Programming only clears bits, erasing sets them all.
+// Ref: http://wiki.nesdev.com/w/index.php/UNROM_512#Flash_data_writing
+
+type sstFlash struct {
+	data    []byte
+	step    int
+	erase   bool // the command sequence is the second one of an erase
+	program bool // the next write programs a byte
+	id      bool
+	written bool
+}
+
+func (f *sstFlash) read(offset int) byte {
+	if f.id {
+		if offset&1 == 0 {
+			return 0xBF // SST
+		}
+		switch len(f.data) {
+		case 0x20000:
+			return 0xB5 // SST39SF010
+		case 0x40000:
+			return 0xB6 // SST39SF020
+		}
+		return 0xB7 // SST39SF040
+	}
+	return f.data[offset%len(f.data)]
+}
+
+func (f *sstFlash) write(offset int, val byte) {
+	offset %= len(f.data)
+	if f.program {
+		f.data[offset] &= val
+		f.program = false
+		f.written = true
+		return
+	}
+	command := offset & 0x7FFF
+	switch {
+	case val == 0xF0:
+		f.id = false
+		f.step = 0
+		f.erase = false
+	case f.step == 0 && command == 0x5555 && val == 0xAA:
+		f.step = 1
+	case f.step == 1 && command == 0x2AAA && val == 0x55:
+		f.step = 2
+	case f.step == 2 && f.erase:
+		switch {
+		case command == 0x5555 && val == 0x10:
+			for i := range f.data {
+				f.data[i] = 0xFF
+			}
+			f.written = true
+		case val == 0x30:
+			sector := offset &^ 0x0FFF
+			for i := sector; i < sector+0x1000; i++ {
+				f.data[i] = 0xFF
+			}
+			f.written = true
+		}
+		f.step = 0
+		f.erase = false
+	case f.step == 2 && command == 0x5555:
+		switch val {
+		case 0xA0:
+			f.program = true
+		case 0x80:
+			f.erase = true
+		case 0x90:
+			f.id = true
+		}
+		f.step = 0
+	default:
+		f.step = 0
+		f.erase = false
+	}
+}
+
+// save is the whole chip if the game wrote to it, see Saver.
+func (f *sstFlash) save() []byte {
+	if !f.written {
+		return nil
+	}
+	return f.data
+}
+
+func (f *sstFlash) load(data []byte) error {
+	if len(data) != len(f.data) {
+		return fmt.Errorf("Flash save is %d bytes, expected %d", len(data), len(f.data))
+	}
+	copy(f.data, data)
+	return nil
+}
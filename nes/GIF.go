@@ -0,0 +1,164 @@
+package nes
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"math"
+	"os"
+)
+
+// GIF clip recorder.
+// Keeps the last few seconds of frames as NES palette indices and writes them
+// as an animated GIF whose palette holds exactly the colors used in the clip,
+// so no dithering or quantization is ever needed.
+
+// NTSC frames per second: 3 PPU dots per CPU cycle, 89341.5 dots per frame.
+const FrameRate = CPUFrequency * 3 / 89341.5
+
+type GIFRecorder struct {
+	path   string
+	skip   int
+	count  int      // frames seen, kept or not
+	frames [][]byte // ring buffer of palette indices
+	next   int
+	full   bool
+}
+
+// NewGIFRecorder keeps the last seconds of video. With halfRate only every
+// other frame is kept, giving a ~30fps clip of half the size. If path is not
+// empty the clip is written there on Close.
+func NewGIFRecorder(path string, seconds float64, halfRate bool) *GIFRecorder {
+	r := GIFRecorder{path: path, skip: 1}
+	if halfRate {
+		r.skip = 2
+	}
+	n := int(math.Ceil(seconds * FrameRate / float64(r.skip)))
+	if n < 1 {
+		n = 1
+	}
+	r.frames = make([][]byte, n)
+	return &r
+}
+
+// Frame converts a frame that did not come from the PPU, color by color.
+func (r *GIFRecorder) Frame(frame *image.RGBA) {
+	buf := r.keep()
+	if buf == nil {
+		return
+	}
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 256; x++ {
+			buf[y*256+x] = PaletteIndex(frame.RGBAAt(x, y))
+		}
+	}
+}
+
+// IndexedFrame copies the palette entries the PPU rendered.
+func (r *GIFRecorder) IndexedFrame(pix []byte) {
+	if buf := r.keep(); buf != nil {
+		copy(buf, pix)
+	}
+}
+
+// keep returns the ring buffer slot for the next frame, or nil if the frame
+// is skipped.
+func (r *GIFRecorder) keep() []byte {
+	r.count++
+	if (r.count-1)%r.skip != 0 {
+		return nil
+	}
+	buf := r.frames[r.next]
+	if buf == nil {
+		buf = make([]byte, 256*240)
+		r.frames[r.next] = buf
+	}
+	r.next++
+	if r.next == len(r.frames) {
+		r.next = 0
+		r.full = true
+	}
+	return buf
+}
+
+func (r *GIFRecorder) Sample(sample float32) {
+}
+
+// clip returns the buffered frames, oldest first.
+func (r *GIFRecorder) clip() [][]byte {
+	if !r.full {
+		return r.frames[:r.next]
+	}
+	return append(append([][]byte{}, r.frames[r.next:]...), r.frames[:r.next]...)
+}
+
+// SaveAs writes the buffered clip to path. Recording continues.
+func (r *GIFRecorder) SaveAs(path string) error {
+	frames := r.clip()
+	if len(frames) == 0 {
+		return errors.New("GIF: no frames recorded")
+	}
+
+	// Build the smallest palette covering every NES color in the clip.
+	var used [64]bool
+	for _, f := range frames {
+		for _, c := range f {
+			used[c] = true
+		}
+	}
+	var palette color.Palette
+	var remap [64]byte
+	seen := make(map[color.RGBA]byte)
+	for i, u := range used {
+		if !u {
+			continue
+		}
+		c := Palette[i]
+		if j, ok := seen[c]; ok {
+			remap[i] = j
+			continue
+		}
+		seen[c] = byte(len(palette))
+		remap[i] = byte(len(palette))
+		palette = append(palette, c)
+	}
+	if len(palette) == 1 {
+		palette = append(palette, color.Black) // GIF needs at least two entries
+	}
+
+	rect := image.Rect(0, 0, 256, 240)
+	g := gif.GIF{Config: image.Config{ColorModel: palette, Width: 256, Height: 240}}
+	// GIF delays are in 1/100 s; spread the rounding error so the clip
+	// keeps the exact length.
+	step := 100 * float64(r.skip) / FrameRate
+	elapsed := 0.0
+	for _, f := range frames {
+		im := image.NewPaletted(rect, palette)
+		for i, c := range f {
+			im.Pix[i] = remap[c]
+		}
+		before := math.Round(elapsed)
+		elapsed += step
+		g.Image = append(g.Image, im)
+		g.Delay = append(g.Delay, int(math.Round(elapsed)-before))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(file, &g); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Close writes the clip to the path given to NewGIFRecorder, if any.
+func (r *GIFRecorder) Close() error {
+	if r.path == "" {
+		return nil
+	}
+	return r.SaveAs(r.path)
+}
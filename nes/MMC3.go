@@ -0,0 +1,110 @@
+package nes
+
+// MMC3 bank switching
+// Eight bank registers: $8000 picks one and $8001 writes it. Registers 0 and
+// 1 are 2KB CHR banks, 2 to 5 1KB CHR banks, 6 and 7 8KB PRG banks. $8000
+// bits 6 and 7 swap the PRG banks at $8000 and $C000, and the CHR halves.
+// Mapper4 and the Namco 108 family share it.
+
+type mmc3Banks struct {
+	*Cartridge
+	register   byte
+	registers  [8]byte
+	prgMode    byte
+	chrMode    byte
+	prgOffsets [4]int
+	chrOffsets [8]int
+}
+
+func newMMC3Banks(cartridge *Cartridge) mmc3Banks {
+	b := mmc3Banks{Cartridge: cartridge}
+	b.prgOffsets[0] = b.prgBankOffset(0)
+	b.prgOffsets[1] = b.prgBankOffset(1)
+	b.prgOffsets[2] = b.prgBankOffset(-2)
+	b.prgOffsets[3] = b.prgBankOffset(-1)
+	return b
+}
+
+func (b *mmc3Banks) readCHR(address uint16) byte {
+	return b.CHR[b.chrOffsets[address/0x0400]+int(address%0x0400)]
+}
+
+func (b *mmc3Banks) writeCHR(address uint16, val byte) {
+	b.WriteCHR(b.chrOffsets[address/0x0400]+int(address%0x0400), val)
+}
+
+func (b *mmc3Banks) readPRG(address uint16) byte {
+	address -= 0x8000
+	return b.PRG[b.prgOffsets[address/0x2000]+int(address%0x2000)]
+}
+
+func (b *mmc3Banks) wBankSelect(val byte) {
+	b.prgMode = (val >> 6) & 1
+	b.chrMode = (val >> 7) & 1
+	b.register = val & 7
+	b.updateOffsets()
+}
+
+func (b *mmc3Banks) wBankData(val byte) {
+	b.registers[b.register] = val
+	b.updateOffsets()
+}
+
+func (b *mmc3Banks) updateOffsets() {
+	switch b.prgMode {
+	case 0:
+		b.prgOffsets[0] = b.prgBankOffset(int(b.registers[6]))
+		b.prgOffsets[1] = b.prgBankOffset(int(b.registers[7]))
+		b.prgOffsets[2] = b.prgBankOffset(-2)
+		b.prgOffsets[3] = b.prgBankOffset(-1)
+	case 1:
+		b.prgOffsets[0] = b.prgBankOffset(-2)
+		b.prgOffsets[1] = b.prgBankOffset(int(b.registers[7]))
+		b.prgOffsets[2] = b.prgBankOffset(int(b.registers[6]))
+		b.prgOffsets[3] = b.prgBankOffset(-1)
+	}
+	switch b.chrMode {
+	case 0:
+		b.chrOffsets[0] = b.chrBankOffset(int(b.registers[0] & 0xFE))
+		b.chrOffsets[1] = b.chrBankOffset(int(b.registers[0] | 0x01))
+		b.chrOffsets[2] = b.chrBankOffset(int(b.registers[1] & 0xFE))
+		b.chrOffsets[3] = b.chrBankOffset(int(b.registers[1] | 0x01))
+		b.chrOffsets[4] = b.chrBankOffset(int(b.registers[2]))
+		b.chrOffsets[5] = b.chrBankOffset(int(b.registers[3]))
+		b.chrOffsets[6] = b.chrBankOffset(int(b.registers[4]))
+		b.chrOffsets[7] = b.chrBankOffset(int(b.registers[5]))
+	case 1:
+		b.chrOffsets[0] = b.chrBankOffset(int(b.registers[2]))
+		b.chrOffsets[1] = b.chrBankOffset(int(b.registers[3]))
+		b.chrOffsets[2] = b.chrBankOffset(int(b.registers[4]))
+		b.chrOffsets[3] = b.chrBankOffset(int(b.registers[5]))
+		b.chrOffsets[4] = b.chrBankOffset(int(b.registers[0] & 0xFE))
+		b.chrOffsets[5] = b.chrBankOffset(int(b.registers[0] | 0x01))
+		b.chrOffsets[6] = b.chrBankOffset(int(b.registers[1] & 0xFE))
+		b.chrOffsets[7] = b.chrBankOffset(int(b.registers[1] | 0x01))
+	}
+}
+
+func (b *mmc3Banks) prgBankOffset(index int) int {
+	if index >= 0x80 {
+		index -= 0x100
+	}
+	index %= len(b.PRG) / 0x2000
+	offset := index * 0x2000
+	if offset < 0 {
+		offset += len(b.PRG)
+	}
+	return offset
+}
+
+func (b *mmc3Banks) chrBankOffset(index int) int {
+	if index >= 0x80 {
+		index -= 0x100
+	}
+	index %= len(b.CHR) / 0x0400
+	offset := index * 0x0400
+	if offset < 0 {
+		offset += len(b.CHR)
+	}
+	return offset
+}
@@ -3,27 +3,94 @@ package nes
 import (
 	"fmt"
 	"log"
+	"sort"
 )
 
+// Mapper is the cartridge hardware seen from the CPU and PPU buses.
+// Read and Write serve both buses: addresses below $2000 are CHR (PPU),
+// $4020 and up are the CPU cartridge space.
 type Mapper interface {
 	Read(address uint16) byte
 	Write(address uint16, val byte)
+	// Run is called once per PPU dot.
 	Run()
+	// Tick is called once per CPU cycle.
+	Tick()
+	// PPUAddress is called with every address the PPU puts on its bus:
+	// pattern, nametable and attribute fetches, including the dummy ones,
+	// $2006 writes and $2007 accesses. It comes before the matching Read
+	// or Write.
+	PPUAddress(address uint16)
+	// ReadNameTable and WriteNameTable serve $2000-$3EFF of the PPU bus.
+	// Cartridge maps them through its nametable slots.
+	ReadNameTable(address uint16) byte
+	WriteNameTable(address uint16, val byte)
+}
+
+// AnySubmapper registers a constructor for every submapper of a mapper
+// number that has no constructor of its own.
+const AnySubmapper = -1
+
+// MapperConstructor builds the mapper of the cartridge loaded in nes.
+type MapperConstructor func(nes *NES) (Mapper, error)
+
+type MapperInfo struct {
+	Number    int
+	Submapper int      // AnySubmapper, or the NES 2.0 submapper handled
+	Boards    []string // Board names, e.g. "SNROM"
+	PPUHooks  bool     // The mapper watches the PPU address bus
+	New       MapperConstructor
+}
+
+var mappers = make(map[[2]int]MapperInfo)
+
+// RegisterMapper makes ctor the constructor of the given mapper and submapper
+// number. The number, submapper and constructor in info are filled in.
+// Registering the same numbers twice replaces the first constructor.
+func RegisterMapper(number, submapper int, ctor MapperConstructor, info MapperInfo) {
+	info.Number = number
+	info.Submapper = submapper
+	info.New = ctor
+	mappers[[2]int{number, submapper}] = info
+}
+
+// LookupMapper finds the constructor for a mapper and submapper number,
+// falling back to the one registered for any submapper.
+func LookupMapper(number, submapper int) (MapperInfo, bool) {
+	if info, ok := mappers[[2]int{number, submapper}]; ok {
+		return info, true
+	}
+	info, ok := mappers[[2]int{number, AnySubmapper}]
+	return info, ok
+}
+
+// Mappers lists every registered mapper, by number and submapper.
+func Mappers() []MapperInfo {
+	var list []MapperInfo
+	for _, info := range mappers {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Number != list[j].Number {
+			return list[i].Number < list[j].Number
+		}
+		return list[i].Submapper < list[j].Submapper
+	})
+	return list
 }
 
 func NewMapper(nes *NES) (Mapper, error) {
-	log.Printf("Mapper type: %d", nes.Cartridge.Mapper)
-	switch nes.Cartridge.Mapper {
-	case 0, 2:
-		return NewMapper2(nes.Cartridge), nil
-	case 1:
-		return NewMapper1(nes.Cartridge), nil
-	case 3:
-		return NewMapper3(nes.Cartridge), nil
-	case 4:
-		return NewMapper4(nes, nes.Cartridge), nil
-	case 7:
-		return NewMapper7(nes.Cartridge), nil
+	if nes.NSF != nil {
+		return NewMapperNSF(nes, nes.NSF), nil
+	}
+	if nes.FDS != nil {
+		return NewMapperFDS(nes, nes.FDS), nil
+	}
+	c := nes.Cartridge
+	log.Printf("Mapper type: %d.%d (%s)", c.Mapper, c.Submapper, c.BoardName())
+	info, ok := LookupMapper(int(c.Mapper), int(c.Submapper))
+	if !ok {
+		return nil, fmt.Errorf("Unknown mapper number: %d (%s)", c.Mapper, c.BoardName())
 	}
-	return nil, fmt.Errorf("Unknown mapper unmber: %d", nes.Cartridge.Mapper)
+	return info.New(nes)
 }
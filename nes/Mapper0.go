@@ -0,0 +1,55 @@
+package nes
+
+import "log"
+
+// NROM
+// 16KB (NROM-128) or 32KB (NROM-256) of PRG, 16KB images appear twice. No
+// bank switching. Family BASIC adds 2KB or 4KB of PRG-RAM at $6000, mirrored
+// up to $7FFF, sized by the NES 2.0 header or the ROM database.
+
+type Mapper0 struct {
+	*Cartridge
+}
+
+func init() {
+	RegisterMapper(0, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapper0(nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"NROM", "HVC-FAMILYBASIC"}})
+}
+
+func NewMapper0(c *Cartridge) Mapper {
+	return &Mapper0{c}
+}
+
+func (m *Mapper0) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[address]
+	case address >= 0x8000:
+		return m.PRG[int(address-0x8000)%len(m.PRG)]
+	case address >= 0x6000:
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal mapper0 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper0) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(int(address), val)
+	case address >= 0x8000: // ROM
+	case address >= 0x6000:
+		m.WriteRAM(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal mapper0 write at address: 0x%04X", address)
+	}
+}
+
+func (m *Mapper0) Run() {
+	return
+}
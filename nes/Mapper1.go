@@ -2,8 +2,17 @@ package nes
 
 import (
 	"log"
+	"strings"
 )
 
+// MMC1
+// Boards with CHR-RAM use the upper bits of the CHR bank registers for other
+// things: SUROM and SXROM select the 256KB half of their 512KB PRG with bit
+// 4, SOROM and SXROM the 8KB PRG-RAM bank with bits 3 and 3-2, and SNROM
+// disables its PRG-RAM with bit 4. In 4KB CHR mode the register in effect is
+// the one of the pattern table the PPU last addressed.
+// Ref: http://wiki.nesdev.com/w/index.php/MMC1
+
 type Mapper1 struct {
 	*Cartridge
 	shiftRegister byte
@@ -15,19 +24,56 @@ type Mapper1 struct {
 	chrBank1      byte
 	prgOffset     [2]int
 	chrOffset     [2]int
+	snrom         bool
+	outerBank     bool // 512KB PRG
+	a12           bool
+	ramOffset     int // offset of the 8KB PRG-RAM bank, -1 if disabled
+	cycle         int // CPU cycles
+	lastWrite     int // cycle of the last serial port write
+}
+
+func init() {
+	RegisterMapper(1, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapper1(nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"SxROM", "SNROM", "SOROM", "SUROM", "SXROM", "MMC1"}, PPUHooks: true})
 }
 
 func NewMapper1(c *Cartridge) Mapper {
 	m := Mapper1{}
 	m.Cartridge = c
 	m.shiftRegister = 0x10
-	m.prgOffset[1] = m.prgBankOffset(-1)
+	m.lastWrite = -1
+	m.outerBank = len(c.PRG) > 0x40000
+	if c.Board != "" {
+		m.snrom = strings.HasSuffix(c.Board, "SNROM")
+	} else {
+		m.snrom = c.CHRRAM && len(c.CHR) == 0x2000 && len(c.SRAM) == 0x2000 && !m.outerBank
+	}
+	m.control = 0x0C
+	m.prgMode = 3
+	m.updateOffset()
 	return &m
 }
 
 func (m *Mapper1) Run() {
 }
 
+// Tick counts CPU cycles, to spot the writes of read-modify-write
+// instructions.
+func (m *Mapper1) Tick() {
+	m.cycle++
+}
+
+func (m *Mapper1) PPUAddress(address uint16) {
+	a12 := address&0x1000 != 0
+	if a12 != m.a12 {
+		m.a12 = a12
+		if m.chrMode == 1 && m.CHRRAM {
+			m.updateOffset()
+		}
+	}
+}
+
 func (m *Mapper1) Read(address uint16) byte {
 	switch {
 	case address < 0x2000:
@@ -40,7 +86,12 @@ func (m *Mapper1) Read(address uint16) byte {
 		offset := address % 0x4000
 		return m.PRG[m.prgOffset[bank]+int(offset)]
 	case address >= 0x6000:
-		return m.SRAM[int(address)-0x6000]
+		if m.ramOffset < 0 || len(m.SRAM) == 0 {
+			return 0
+		}
+		return m.SRAM[(m.ramOffset+int(address-0x6000))%len(m.SRAM)]
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper1 read at address: $%04X", address)
 	}
@@ -52,11 +103,20 @@ func (m *Mapper1) Write(address uint16, val byte) {
 	case address < 0x2000:
 		bank := address / 0x1000
 		offset := address % 0x1000
-		m.CHR[m.chrOffset[bank]+int(offset)] = val
+		m.WriteCHR(m.chrOffset[bank]+int(offset), val)
 	case address >= 0x8000:
+		// The MMC1 ignores a write on the cycle after another. All the
+		// writes of an instruction happen before its cycles are ticked.
+		if m.cycle == m.lastWrite {
+			return
+		}
+		m.lastWrite = m.cycle
 		m.loadRegister(address, val)
 	case address >= 0x6000:
-		m.SRAM[int(address)-0x6000] = val
+		if m.ramOffset >= 0 && len(m.SRAM) != 0 {
+			m.SRAM[(m.ramOffset+int(address-0x6000))%len(m.SRAM)] = val
+		}
+	case address >= 0x4020: // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper1 write at address: $%04X", address)
 	}
@@ -98,13 +158,13 @@ func (m *Mapper1) wCtrl(val byte) {
 	mirror := val & 3
 	switch mirror {
 	case 0:
-		m.Cartridge.Mirror = MirrorSingle0
+		m.SetMirror(MirrorSingle0)
 	case 1:
-		m.Cartridge.Mirror = MirrorSingle1
+		m.SetMirror(MirrorSingle1)
 	case 2:
-		m.Cartridge.Mirror = MirrorVertical
+		m.SetMirror(MirrorVertical)
 	case 3:
-		m.Cartridge.Mirror = MirrorHorizontal
+		m.SetMirror(MirrorHorizontal)
 	}
 	m.updateOffset()
 }
@@ -151,17 +211,45 @@ func (m *Mapper1) chrBankOffset(index int) int {
 	return offset
 }
 
+// chrRegister is the CHR bank register whose upper bits are in effect.
+func (m *Mapper1) chrRegister() byte {
+	if m.chrMode == 1 && m.a12 {
+		return m.chrBank1
+	}
+	return m.chrBank0
+}
+
 func (m *Mapper1) updateOffset() {
+	reg := m.chrRegister()
+	outer := 0
+	if m.outerBank {
+		outer = int(reg & 0x10)
+	}
+	bank := int(m.prgBank) | outer
 	switch m.prgMode {
 	case 0, 1:
-		m.prgOffset[0] = m.prgBankOffset(int(m.prgBank & 0xFE))
-		m.prgOffset[1] = m.prgBankOffset(int(m.prgBank | 0x01))
+		m.prgOffset[0] = m.prgBankOffset(bank & 0xFE)
+		m.prgOffset[1] = m.prgBankOffset(bank | 0x01)
 	case 2:
-		m.prgOffset[0] = 0
-		m.prgOffset[1] = m.prgBankOffset(int(m.prgBank))
+		m.prgOffset[0] = m.prgBankOffset(outer)
+		m.prgOffset[1] = m.prgBankOffset(bank)
 	case 3:
-		m.prgOffset[0] = m.prgBankOffset(int(m.prgBank))
-		m.prgOffset[1] = m.prgBankOffset(-1)
+		m.prgOffset[0] = m.prgBankOffset(bank)
+		m.prgOffset[1] = m.prgBankOffset(outer | 0x0F)
+	}
+	switch {
+	case m.snrom && reg&0x10 != 0:
+		m.ramOffset = -1
+	case len(m.SRAM) >= 0x8000: // SXROM
+		m.ramOffset = int(reg>>2&3) * 0x2000
+	case len(m.SRAM) >= 0x4000: // SOROM, with the battery on the second bank
+		ramBank := int(reg >> 3 & 1)
+		if m.PRGNVRAM == 0x2000 {
+			ramBank ^= 1
+		}
+		m.ramOffset = ramBank * 0x2000
+	default:
+		m.ramOffset = 0
 	}
 	switch m.chrMode {
 	case 0:
@@ -4,14 +4,21 @@ import "log"
 
 type Mapper2 struct {
 	*Cartridge
-	prgBank  int
-	prgBank1 int
-	prgBank2 int
+	prgBank   int
+	prgBank1  int
+	prgBank2  int
+	conflicts bool
+}
+
+func init() {
+	RegisterMapper(2, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapper2(nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"UxROM"}})
 }
 
 func NewMapper2(c *Cartridge) Mapper {
 	prgBank := len(c.PRG) / 0x4000
-	return &Mapper2{c, prgBank, 0, prgBank - 1}
+	return &Mapper2{c, prgBank, 0, prgBank - 1, c.BusConflicts(true)}
 }
 
 func (m *Mapper2) Read(address uint16) byte {
@@ -25,8 +32,9 @@ func (m *Mapper2) Read(address uint16) byte {
 		idx := m.prgBank1*0x4000 + int(address-0x8000)
 		return m.PRG[idx]
 	case address >= 0x6000:
-		idx := int(address) - 0x6000
-		return m.SRAM[idx]
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper2 read at address: $%04X", address)
 	}
@@ -36,12 +44,15 @@ func (m *Mapper2) Read(address uint16) byte {
 func (m *Mapper2) Write(address uint16, val byte) {
 	switch {
 	case address < 0x2000:
-		m.CHR[address] = val
+		m.WriteCHR(int(address), val)
 	case address >= 0x8000:
+		if m.conflicts {
+			val &= m.Read(address)
+		}
 		m.prgBank1 = int(val) % m.prgBank
 	case address >= 0x6000:
-		idx := int(address) - 0x6000
-		m.SRAM[idx] = val
+		m.WriteRAM(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper2 write at address: 0x%04X", address)
 	}
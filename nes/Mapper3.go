@@ -4,14 +4,21 @@ import "log"
 
 type Mapper3 struct {
 	*Cartridge
-	chrBank  int
-	prgBank1 int
-	prgBank2 int
+	chrBank   int
+	prgBank1  int
+	prgBank2  int
+	conflicts bool
+}
+
+func init() {
+	RegisterMapper(3, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapper3(nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"CNROM"}})
 }
 
 func NewMapper3(cartridge *Cartridge) Mapper {
 	prgBanks := len(cartridge.PRG) / 0x4000
-	return &Mapper3{cartridge, 0, 0, prgBanks - 1}
+	return &Mapper3{cartridge, 0, 0, prgBanks - 1, cartridge.BusConflicts(true)}
 }
 
 func (m *Mapper3) Read(address uint16) byte {
@@ -26,8 +33,9 @@ func (m *Mapper3) Read(address uint16) byte {
 		index := m.prgBank1*0x4000 + int(address-0x8000)
 		return m.PRG[index]
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		return m.SRAM[index]
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper3 read at address: $%04X", address)
 	}
@@ -38,12 +46,15 @@ func (m *Mapper3) Write(address uint16, val byte) {
 	switch {
 	case address < 0x2000:
 		index := m.chrBank*0x2000 + int(address)
-		m.CHR[index] = val
+		m.WriteCHR(index, val)
 	case address >= 0x8000:
+		if m.conflicts {
+			val &= m.Read(address)
+		}
 		m.chrBank = int(val & 3)
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		m.SRAM[index] = val
+		m.WriteRAM(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper2 write at address: $%04X", address)
 	}
@@ -1,57 +1,96 @@
 package nes
 
 // https://github.com/asfdfdfd/fceux/blob/master/src/boards/mmc3.cpp
+// The scanline counter is clocked by rises of PPU A12 after it stayed low for
+// a while, which filters out the short drops between sprite fetches.
+// Ref: http://wiki.nesdev.com/w/index.php/MMC3
+// Ref: http://wiki.nesdev.com/w/index.php/MMC6
 import (
 	"log"
+	"strings"
 )
 
+// Revisions, by NES 2.0 submapper
+const (
+	mmc3Sharp = iota // MMC3B/C: IRQ whenever the counter is 0 after a clock
+	mmc3NEC          // MMC3A: IRQ only when the counter gets to 0
+	mmc6             // 1KB of RAM inside, IRQ like the Sharp MMC3
+)
+
+// PPU dots A12 must stay low before a rise clocks the counter, about 3 CPU
+// cycles.
+const mmc3A12Filter = 10
+
 type Mapper4 struct {
-	*Cartridge
+	mmc3Banks
 	nes        *NES
-	register   byte
-	registers  [8]byte
-	prgMode    byte
-	chrMode    byte
-	prgOffsets [4]int
-	chrOffsets [8]int
+	revision   byte
 	reload     byte
 	counter    byte
+	reloading  bool // $C001 was written
 	irqEnable  bool
+	ramEnable  bool // MMC3 $A001, MMC6 $8000 bit 5
+	ramProtect byte // $A001
+	a12        bool
+	a12Low     int // dot A12 went low
+	dots       int
+}
+
+func init() {
+	newMapper4 := func(nes *NES) (Mapper, error) {
+		return NewMapper4(nes, nes.Cartridge), nil
+	}
+	RegisterMapper(4, AnySubmapper, newMapper4, MapperInfo{Boards: []string{"TxROM", "MMC3"}, PPUHooks: true})
+	RegisterMapper(4, 1, newMapper4, MapperInfo{Boards: []string{"HKROM", "MMC6"}, PPUHooks: true})
+	RegisterMapper(4, 4, newMapper4, MapperInfo{Boards: []string{"TxROM", "MMC3A"}, PPUHooks: true})
 }
 
 func NewMapper4(nes *NES, cartridge *Cartridge) Mapper {
-	m := Mapper4{Cartridge: cartridge, nes: nes}
-	m.prgOffsets[0] = m.prgBankOffset(0)
-	m.prgOffsets[1] = m.prgBankOffset(1)
-	m.prgOffsets[2] = m.prgBankOffset(-2)
-	m.prgOffsets[3] = m.prgBankOffset(-1)
+	m := Mapper4{mmc3Banks: newMMC3Banks(cartridge), nes: nes, ramEnable: true}
+	switch {
+	case cartridge.Submapper == 1 || strings.HasSuffix(cartridge.Board, "HKROM"):
+		m.revision = mmc6
+		m.ramEnable = false
+		if len(m.SRAM) < 0x0400 {
+			m.SetRAM(0, 0x0400)
+		}
+	case cartridge.Submapper == 4:
+		m.revision = mmc3NEC
+	}
 	return &m
 }
 
-func (m *Mapper4) HandleScanLine() {
-	if m.counter == 0 {
+func (m *Mapper4) clockCounter() {
+	zero := m.counter == 0
+	if m.counter == 0 || m.reloading {
 		m.counter = m.reload
 	} else {
 		m.counter--
-		if m.counter == 0 && m.irqEnable {
-			m.nes.CPU.tIRQ()
+	}
+	if m.counter == 0 && m.irqEnable {
+		if m.revision != mmc3NEC || !zero || m.reloading {
+			m.nes.CPU.SetIRQ(IRQMapper, true)
 		}
 	}
+	m.reloading = false
 }
 
 func (m *Mapper4) Read(address uint16) byte {
 	switch {
 	case address < 0x2000:
-		bank := address / 0x0400
-		offset := address % 0x0400
-		return m.CHR[m.chrOffsets[bank]+int(offset)]
+		return m.readCHR(address)
 	case address >= 0x8000:
-		address = address - 0x8000
-		bank := address / 0x2000
-		offset := address % 0x2000
-		return m.PRG[m.prgOffsets[bank]+int(offset)]
+		return m.readPRG(address)
 	case address >= 0x6000:
-		return m.SRAM[int(address)-0x6000]
+		if m.revision == mmc6 {
+			return m.rMMC6RAM(address)
+		}
+		if !m.ramEnable {
+			return 0
+		}
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper4 read at address: $%04X", address)
 	}
@@ -61,15 +100,18 @@ func (m *Mapper4) Read(address uint16) byte {
 func (m *Mapper4) Write(address uint16, val byte) {
 	switch {
 	case address < 0x2000:
-		bank := address / 0x0400
-		offset := address % 0x0400
-		m.CHR[m.chrOffsets[bank]+int(offset)] = val
+		m.writeCHR(address, val)
 	case address >= 0x8000:
 		m.wRegister(address, val)
 	case address >= 0x6000:
-		m.SRAM[int(address)-0x6000] = val
+		if m.revision == mmc6 {
+			m.wMMC6RAM(address, val)
+		} else if m.ramEnable && m.ramProtect&0x40 == 0 {
+			m.WriteRAM(address, val)
+		}
+	case address >= 0x4020: // nothing on the expansion bus
 	default:
-		log.Fatalf("Illegal mapper4 read at address: $%04X", address)
+		log.Fatalf("Illegal mapper4 write at address: $%04X", address)
 	}
 }
 
@@ -95,63 +137,69 @@ func (m *Mapper4) wRegister(address uint16, val byte) {
 }
 
 func (m *Mapper4) wBankSelect(val byte) {
-	m.prgMode = (val >> 6) & 1
-	m.chrMode = (val >> 7) & 1
-	m.register = val & 7
-	m.updateOffsets()
-}
-
-func (m *Mapper4) wBankData(val byte) {
-	m.registers[m.register] = val
-	m.updateOffsets()
-}
-
-func (m *Mapper4) updateOffsets() {
-	switch m.prgMode {
-	case 0:
-		m.prgOffsets[0] = m.prgBankOffset(int(m.registers[6]))
-		m.prgOffsets[1] = m.prgBankOffset(int(m.registers[7]))
-		m.prgOffsets[2] = m.prgBankOffset(-2)
-		m.prgOffsets[3] = m.prgBankOffset(-1)
-	case 1:
-		m.prgOffsets[0] = m.prgBankOffset(-2)
-		m.prgOffsets[1] = m.prgBankOffset(int(m.registers[7]))
-		m.prgOffsets[2] = m.prgBankOffset(int(m.registers[6]))
-		m.prgOffsets[3] = m.prgBankOffset(-1)
-	}
-	switch m.chrMode {
-	case 0:
-		m.chrOffsets[0] = m.chrBankOffset(int(m.registers[0] & 0xFE))
-		m.chrOffsets[1] = m.chrBankOffset(int(m.registers[0] | 0x01))
-		m.chrOffsets[2] = m.chrBankOffset(int(m.registers[1] & 0xFE))
-		m.chrOffsets[3] = m.chrBankOffset(int(m.registers[1] | 0x01))
-		m.chrOffsets[4] = m.chrBankOffset(int(m.registers[2]))
-		m.chrOffsets[5] = m.chrBankOffset(int(m.registers[3]))
-		m.chrOffsets[6] = m.chrBankOffset(int(m.registers[4]))
-		m.chrOffsets[7] = m.chrBankOffset(int(m.registers[5]))
-	case 1:
-		m.chrOffsets[0] = m.chrBankOffset(int(m.registers[2]))
-		m.chrOffsets[1] = m.chrBankOffset(int(m.registers[3]))
-		m.chrOffsets[2] = m.chrBankOffset(int(m.registers[4]))
-		m.chrOffsets[3] = m.chrBankOffset(int(m.registers[5]))
-		m.chrOffsets[4] = m.chrBankOffset(int(m.registers[0] & 0xFE))
-		m.chrOffsets[5] = m.chrBankOffset(int(m.registers[0] | 0x01))
-		m.chrOffsets[6] = m.chrBankOffset(int(m.registers[1] & 0xFE))
-		m.chrOffsets[7] = m.chrBankOffset(int(m.registers[1] | 0x01))
+	if m.revision == mmc6 {
+		m.ramEnable = val&0x20 != 0
+		if !m.ramEnable {
+			m.ramProtect = 0
+		}
 	}
+	m.mmc3Banks.wBankSelect(val)
 }
 
 func (m *Mapper4) wMirror(val byte) {
+	if m.Mirror == MirrorFour { // TVROM and TR1ROM are wired to their VRAM
+		return
+	}
 	switch val & 1 {
 	case 0:
-		m.Cartridge.Mirror = MirrorVertical
+		m.SetMirror(MirrorVertical)
 	case 1:
-		m.Cartridge.Mirror = MirrorHorizontal
+		m.SetMirror(MirrorHorizontal)
 	}
 }
 
+// PRG-RAM protect - $A001
+// MMC3: bit 7 enables the RAM, bit 6 denies writes.
+// MMC6: bits 5/4 allow reading/writing $7000-$71FF and bits 7/6 $7200-$73FF,
+// only while $8000 bit 5 is set.
 func (m *Mapper4) wProtect(val byte) {
-	return
+	if m.revision != mmc6 {
+		m.ramEnable = val&0x80 != 0
+		m.ramProtect = val
+	} else if m.ramEnable {
+		m.ramProtect = val
+	}
+}
+
+// MMC6 RAM is 1KB at $7000, mirrored up to $7FFF. It reads open bus if no
+// half can be read, and 0 from a half that can't be read while the other can.
+func (m *Mapper4) rMMC6RAM(address uint16) byte {
+	if address < 0x7000 || !m.ramEnable || m.ramProtect&0xA0 == 0 {
+		return 0
+	}
+	offset := int(address-0x7000) % 0x0400
+	if m.ramProtect&mmc6ReadBit(offset) == 0 {
+		return 0
+	}
+	return m.SRAM[offset]
+}
+
+func (m *Mapper4) wMMC6RAM(address uint16, val byte) {
+	if address < 0x7000 || !m.ramEnable {
+		return
+	}
+	offset := int(address-0x7000) % 0x0400
+	read := mmc6ReadBit(offset)
+	if m.ramProtect&read != 0 && m.ramProtect&(read>>1) != 0 {
+		m.SRAM[offset] = val
+	}
+}
+
+func mmc6ReadBit(offset int) byte {
+	if offset < 0x0200 {
+		return 0x20
+	}
+	return 0x80
 }
 
 func (m *Mapper4) wIRQLatch(val byte) {
@@ -160,50 +208,30 @@ func (m *Mapper4) wIRQLatch(val byte) {
 
 func (m *Mapper4) wIRQReload(val byte) {
 	m.counter = 0
+	m.reloading = true
 }
 
 func (m *Mapper4) wIRQDisable(val byte) {
 	m.irqEnable = false
+	m.nes.CPU.SetIRQ(IRQMapper, false)
 }
 
 func (m *Mapper4) wIRQEnable(val byte) {
 	m.irqEnable = true
 }
 
-func (m *Mapper4) prgBankOffset(index int) int {
-	if index >= 0x80 {
-		index -= 0x100
-	}
-	index %= len(m.PRG) / 0x2000
-	offset := index * 0x2000
-	if offset < 0 {
-		offset += len(m.PRG)
-	}
-	return offset
-}
-
-func (m *Mapper4) chrBankOffset(index int) int {
-	if index >= 0x80 {
-		index -= 0x100
-	}
-	index %= len(m.CHR) / 0x0400
-	offset := index * 0x0400
-	if offset < 0 {
-		offset += len(m.CHR)
-	}
-	return offset
+// Run counts PPU dots for the A12 filter.
+func (m *Mapper4) Run() {
+	m.dots++
 }
 
-func (m *Mapper4) Run() {
-	ppu := m.nes.PPU
-	if ppu.Cycle != 280 {
-		return
+func (m *Mapper4) PPUAddress(address uint16) {
+	a12 := address&0x1000 != 0
+	if a12 && !m.a12 && m.dots-m.a12Low >= mmc3A12Filter {
+		m.clockCounter()
 	}
-	if ppu.ScanLine > 239 && ppu.ScanLine < 261 {
-		return
-	}
-	if ppu.fShowBackground == 0 && ppu.fShowSprites == 0 {
-		return
+	if !a12 && m.a12 {
+		m.a12Low = m.dots
 	}
-	m.HandleScanLine()
+	m.a12 = a12
 }
@@ -0,0 +1,535 @@
+package nes
+
+import "log"
+
+// MMC5
+// Ref: http://wiki.nesdev.com/w/index.php/MMC5
+// The MMC5 tells the PPU fetches apart by watching the PPU bus: three reads
+// of the same nametable address start a scanline, and reads stopping for a
+// while end the frame. Whether a pattern fetch is for the background or for
+// sprites, which the real chip gets from counting fetches, is taken from the
+// PPU dot here.
+
+const (
+	mmc5Idle   = 100 // PPU dots without reads that end the frame
+	mmc5Sprite = 257 // sprite pattern fetches are on dots 257-320
+)
+
+// Background fetch kinds
+const (
+	mmc5Normal = iota
+	mmc5ExAttr // extended attributes: CHR bank and palette from ExRAM
+	mmc5Split  // inside the vertical split
+)
+
+type Mapper5 struct {
+	*Cartridge
+	nes        *NES
+	prgMode    byte
+	chrMode    byte
+	prgProtect [2]byte
+	exMode     byte    // $5104
+	ntMapping  byte    // $5105
+	prgBanks   [5]byte // $5113-$5117
+	chrA       [8]int  // $5120-$5127, with the upper bits
+	chrB       [4]int  // $5128-$512B
+	chrUpper   byte
+	lastB      bool // last CHR register written was in set B
+	exRAM      [0x0400]byte
+	fill       [0x0400]byte // the fill mode nametable
+	zero       [0x0400]byte
+
+	splitCtrl   byte // $5200
+	splitScroll byte
+	splitBank   byte
+
+	irqCompare byte
+	irqEnable  bool
+	irqPending bool
+	inFrame    bool
+	scanline   byte
+	lastNT     uint16
+	ntReads    int
+	idle       int
+
+	fetch  byte // kind of the background tile being fetched
+	exTile byte // its ExRAM byte
+	splitY int  // its row in the split
+
+	multiplicand byte
+	multiplier   byte
+
+	pulse1    Square
+	pulse2    Square
+	pcm       byte
+	pcmRead   bool
+	pcmIRQ    bool
+	pcmEnable bool
+	cycle     uint64
+}
+
+func init() {
+	RegisterMapper(5, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapper5(nes, nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"ExROM", "MMC5"}, PPUHooks: true})
+}
+
+func NewMapper5(nes *NES, cartridge *Cartridge) Mapper {
+	m := Mapper5{Cartridge: cartridge, nes: nes, prgMode: 3, chrMode: 3}
+	for i := range m.prgBanks {
+		m.prgBanks[i] = 0xFF
+	}
+	m.updateNameTables()
+	return &m
+}
+
+// PRG
+
+// prgBank returns the bank register for an 8KB slot of $6000-$FFFF, with
+// bit 7 set for ROM.
+func (m *Mapper5) prgBank(slot int) byte {
+	if slot == 0 {
+		return m.prgBanks[0] & 0x7F
+	}
+	switch m.prgMode {
+	case 0:
+		return m.prgBanks[4]&0x7C | byte(slot-1) | 0x80
+	case 1:
+		if slot <= 2 {
+			return m.prgBanks[2]&0xFE | byte(slot-1)
+		}
+		return m.prgBanks[4]&0x7E | byte(slot-3) | 0x80
+	case 2:
+		if slot <= 2 {
+			return m.prgBanks[2]&0xFE | byte(slot-1)
+		}
+	}
+	if slot == 4 {
+		return m.prgBanks[4] | 0x80
+	}
+	return m.prgBanks[slot]
+}
+
+func (m *Mapper5) readPRG(address uint16) byte {
+	slot := int(address-0x6000) / 0x2000
+	offset := int(address) % 0x2000
+	bank := m.prgBank(slot)
+	if bank&0x80 != 0 {
+		return m.PRG[(int(bank&0x7F)*0x2000+offset)%len(m.PRG)]
+	}
+	if len(m.SRAM) == 0 {
+		return 0
+	}
+	return m.SRAM[(int(bank&0x07)*0x2000+offset)%len(m.SRAM)]
+}
+
+func (m *Mapper5) writePRG(address uint16, val byte) {
+	slot := int(address-0x6000) / 0x2000
+	offset := int(address) % 0x2000
+	bank := m.prgBank(slot)
+	if bank&0x80 != 0 || len(m.SRAM) == 0 {
+		return
+	}
+	if m.prgProtect[0]&3 != 2 || m.prgProtect[1]&3 != 1 {
+		return
+	}
+	m.SRAM[(int(bank&0x07)*0x2000+offset)%len(m.SRAM)] = val
+}
+
+// CHR
+
+// chrOffset maps a pattern table address through CHR set A or B.
+func (m *Mapper5) chrOffset(address uint16, setB bool) int {
+	var bank, size int
+	switch m.chrMode {
+	case 0:
+		size = 0x2000
+		if setB {
+			bank = m.chrB[3]
+		} else {
+			bank = m.chrA[7]
+		}
+	case 1:
+		size = 0x1000
+		if setB {
+			bank = m.chrB[3]
+		} else {
+			bank = m.chrA[address/0x1000*4+3]
+		}
+	case 2:
+		size = 0x0800
+		if setB {
+			bank = m.chrB[address/0x0800%2*2+1]
+		} else {
+			bank = m.chrA[address/0x0800*2+1]
+		}
+	case 3:
+		size = 0x0400
+		if setB {
+			bank = m.chrB[address/0x0400%4]
+		} else {
+			bank = m.chrA[address/0x0400]
+		}
+	}
+	return (bank*size + int(address)%size) % len(m.CHR)
+}
+
+// rendering reports whether the PPU is fetching for the picture.
+func (m *Mapper5) rendering() bool {
+	p := m.nes.PPU
+	if p.fShowBackground == 0 && p.fShowSprites == 0 {
+		return false
+	}
+	return p.ScanLine < 240 || p.ScanLine == 261
+}
+
+func (m *Mapper5) spriteFetch() bool {
+	c := m.nes.PPU.Cycle
+	return c >= mmc5Sprite && c <= 320
+}
+
+func (m *Mapper5) readCHR(address uint16) byte {
+	if !m.rendering() {
+		if m.nes.PPU.fSpriteSize == 0 {
+			return m.CHR[m.chrOffset(address, false)]
+		}
+		return m.CHR[m.chrOffset(address, m.lastB)]
+	}
+	if m.spriteFetch() {
+		return m.CHR[m.chrOffset(address, false)]
+	}
+	switch m.fetch {
+	case mmc5ExAttr:
+		bank := int(m.exTile&0x3F) | int(m.chrUpper&3)<<6
+		return m.CHR[(bank*0x1000+int(address)%0x1000)%len(m.CHR)]
+	case mmc5Split:
+		row := address&0x0FF8 | uint16(m.splitY&7)
+		return m.CHR[(int(m.splitBank)*0x1000+int(row))%len(m.CHR)]
+	}
+	return m.CHR[m.chrOffset(address, m.nes.PPU.fSpriteSize == 1)]
+}
+
+// Nametables
+
+// updateNameTables maps the four slots as $5105 says: CIRAM page 0 or 1,
+// ExRAM, or the fill mode nametable.
+func (m *Mapper5) updateNameTables() {
+	for slot := 0; slot < 4; slot++ {
+		switch m.ntMapping >> uint(slot*2) & 3 {
+		case 0:
+			m.MapNameTable(slot, m.CIRAM[0x0000:], true)
+		case 1:
+			m.MapNameTable(slot, m.CIRAM[0x0400:], true)
+		case 2:
+			if m.exMode <= 1 {
+				m.MapNameTable(slot, m.exRAM[:], true)
+			} else {
+				m.MapNameTable(slot, m.zero[:], false)
+			}
+		case 3:
+			m.MapNameTable(slot, m.fill[:], false)
+		}
+	}
+}
+
+func (m *Mapper5) updateFill(tile, color byte) {
+	for i := 0; i < 0x03C0; i++ {
+		m.fill[i] = tile
+	}
+	for i := 0x03C0; i < 0x0400; i++ {
+		m.fill[i] = color & 3 * 0x55
+	}
+}
+
+// tileColumn is the column of the background tile being fetched, counting
+// the two prefetched at the end of the previous scanline.
+func (m *Mapper5) tileColumn() int {
+	c := m.nes.PPU.Cycle
+	if c >= 321 {
+		return (c - 321) / 8
+	}
+	return (c-1)/8 + 2
+}
+
+func (m *Mapper5) inSplit(column int) bool {
+	if m.splitCtrl&0x80 == 0 || m.exMode > 1 {
+		return false
+	}
+	threshold := int(m.splitCtrl & 0x1F)
+	if m.splitCtrl&0x40 == 0 {
+		return column < threshold
+	}
+	return column >= threshold
+}
+
+func (m *Mapper5) ReadNameTable(address uint16) byte {
+	if !m.rendering() || m.spriteFetch() {
+		return m.Cartridge.ReadNameTable(address)
+	}
+	offset := (address - 0x2000) % 0x0400
+	if offset < 0x03C0 {
+		// Tile fetch: decides how the attribute and pattern fetches go.
+		column := m.tileColumn()
+		if m.inSplit(column) {
+			line := m.nes.PPU.ScanLine
+			if m.nes.PPU.Cycle >= 321 {
+				line = (line + 1) % 262
+			}
+			m.fetch = mmc5Split
+			m.splitY = (int(m.splitScroll) + line) % 240
+			return m.exRAM[m.splitY/8*32+column%32]
+		}
+		if m.exMode == 1 {
+			m.fetch = mmc5ExAttr
+			m.exTile = m.exRAM[offset]
+		} else {
+			m.fetch = mmc5Normal
+		}
+		return m.Cartridge.ReadNameTable(address)
+	}
+	switch m.fetch {
+	case mmc5Split:
+		column := m.tileColumn() % 32
+		at := m.exRAM[0x03C0+m.splitY/32*8+column/4]
+		shift := uint(m.splitY/16%2*4 + column/2%2*2)
+		return at >> shift & 3 * 0x55
+	case mmc5ExAttr:
+		return m.exTile >> 6 * 0x55
+	}
+	return m.Cartridge.ReadNameTable(address)
+}
+
+// PPU bus watching
+
+func (m *Mapper5) PPUAddress(address uint16) {
+	m.idle = 0
+	if address >= 0x2000 && address < 0x3000 && address == m.lastNT {
+		m.ntReads++
+		if m.ntReads == 2 {
+			m.startScanline()
+		}
+	} else {
+		m.ntReads = 0
+	}
+	m.lastNT = address
+}
+
+func (m *Mapper5) startScanline() {
+	if !m.inFrame {
+		m.inFrame = true
+		m.scanline = 0
+	} else {
+		m.scanline++
+		if m.scanline == m.irqCompare {
+			m.irqPending = true
+		}
+	}
+	m.updateIRQ()
+}
+
+// Run ends the frame when the PPU stops reading.
+func (m *Mapper5) Run() {
+	m.idle++
+	if m.idle == mmc5Idle {
+		m.inFrame = false
+		m.lastNT = 0
+	}
+}
+
+func (m *Mapper5) updateIRQ() {
+	m.nes.CPU.SetIRQ(IRQMapper, m.irqPending && m.irqEnable)
+	m.nes.CPU.SetIRQ(IRQExpansion, m.pcmIRQ && m.pcmEnable)
+}
+
+// CPU bus
+
+func (m *Mapper5) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.readCHR(address)
+	case address >= 0x6000:
+		val := m.readPRG(address)
+		if m.pcmRead && address >= 0x8000 && address < 0xC000 {
+			m.wPCM(val)
+		}
+		return val
+	case address >= 0x5C00:
+		if m.exMode >= 2 {
+			return m.exRAM[address-0x5C00]
+		}
+		return 0
+	case address == 0x5010:
+		var val byte
+		if m.pcmIRQ {
+			val = 0x80
+		}
+		m.pcmIRQ = false
+		m.updateIRQ()
+		return val
+	case address == 0x5015:
+		var val byte
+		if m.pulse1.lValue > 0 {
+			val |= 1
+		}
+		if m.pulse2.lValue > 0 {
+			val |= 2
+		}
+		return val
+	case address == 0x5204:
+		var val byte
+		if m.irqPending {
+			val |= 0x80
+		}
+		if m.inFrame {
+			val |= 0x40
+		}
+		m.irqPending = false
+		m.updateIRQ()
+		return val
+	case address == 0x5205:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier))
+	case address == 0x5206:
+		return byte(uint16(m.multiplicand) * uint16(m.multiplier) >> 8)
+	case address >= 0x4020:
+		return 0 // open bus
+	default:
+		log.Fatalf("Illegal mapper5 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *Mapper5) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.lastB = false
+		m.WriteCHR(m.chrOffset(address, false), val)
+	case address >= 0x6000:
+		m.writePRG(address, val)
+	case address >= 0x5C00:
+		switch m.exMode {
+		case 0, 1:
+			if !m.inFrame {
+				val = 0
+			}
+			m.exRAM[address-0x5C00] = val
+		case 2:
+			m.exRAM[address-0x5C00] = val
+		}
+	case address >= 0x5000 && address <= 0x5015:
+		m.wAudio(address, val)
+	case address == 0x5100:
+		m.prgMode = val & 3
+	case address == 0x5101:
+		m.chrMode = val & 3
+	case address == 0x5102, address == 0x5103:
+		m.prgProtect[address-0x5102] = val
+	case address == 0x5104:
+		m.exMode = val & 3
+		m.updateNameTables()
+	case address == 0x5105:
+		m.ntMapping = val
+		m.updateNameTables()
+	case address == 0x5106:
+		m.updateFill(val, m.fill[0x03C0])
+	case address == 0x5107:
+		m.updateFill(m.fill[0], val)
+	case address >= 0x5113 && address <= 0x5117:
+		m.prgBanks[address-0x5113] = val
+	case address >= 0x5120 && address <= 0x5127:
+		m.chrA[address-0x5120] = int(val) | int(m.chrUpper&3)<<8
+		m.lastB = false
+	case address >= 0x5128 && address <= 0x512B:
+		m.chrB[address-0x5128] = int(val) | int(m.chrUpper&3)<<8
+		m.lastB = true
+	case address == 0x5130:
+		m.chrUpper = val & 3
+	case address == 0x5200:
+		m.splitCtrl = val
+	case address == 0x5201:
+		m.splitScroll = val
+	case address == 0x5202:
+		m.splitBank = val
+	case address == 0x5203:
+		m.irqCompare = val
+	case address == 0x5204:
+		m.irqEnable = val&0x80 != 0
+		m.updateIRQ()
+	case address == 0x5205:
+		m.multiplicand = val
+	case address == 0x5206:
+		m.multiplier = val
+	case address >= 0x4020: // nothing
+	default:
+		log.Fatalf("Illegal mapper5 write at address: $%04X", address)
+	}
+}
+
+// Audio
+// Two pulse channels like the APU's, without sweep, and an 8-bit PCM
+// channel. Envelopes and length counters run at a fixed 240Hz.
+
+func (m *Mapper5) wAudio(address uint16, val byte) {
+	switch address {
+	case 0x5000:
+		m.pulse1.wCtrl(val)
+	case 0x5002:
+		m.pulse1.wTimerLow(val)
+	case 0x5003:
+		m.pulse1.wTimerHigh(val)
+	case 0x5004:
+		m.pulse2.wCtrl(val)
+	case 0x5006:
+		m.pulse2.wTimerLow(val)
+	case 0x5007:
+		m.pulse2.wTimerHigh(val)
+	case 0x5010:
+		m.pcmRead = val&1 != 0
+		m.pcmEnable = val&0x80 != 0
+		m.updateIRQ()
+	case 0x5011:
+		if !m.pcmRead {
+			m.wPCM(val)
+		}
+	case 0x5015:
+		m.pulse1.enabled = val&1 != 0
+		m.pulse2.enabled = val&2 != 0
+		if !m.pulse1.enabled {
+			m.pulse1.lValue = 0
+		}
+		if !m.pulse2.enabled {
+			m.pulse2.lValue = 0
+		}
+	}
+}
+
+// wPCM plays a PCM byte. 0 is not played: in read mode it raises the IRQ.
+func (m *Mapper5) wPCM(val byte) {
+	if val == 0 {
+		if m.pcmRead {
+			m.pcmIRQ = true
+			m.updateIRQ()
+		}
+		return
+	}
+	m.pcm = val
+}
+
+// Tick runs the audio.
+func (m *Mapper5) Tick() {
+	cycle1 := m.cycle
+	m.cycle++
+	if m.cycle%2 == 0 {
+		m.pulse1.rTimer()
+		m.pulse2.rTimer()
+	}
+	if int(float64(cycle1)/fCounterRate) != int(float64(m.cycle)/fCounterRate) {
+		m.pulse1.rEnvelope()
+		m.pulse2.rEnvelope()
+		m.pulse1.rLength()
+		m.pulse2.rLength()
+	}
+}
+
+func (m *Mapper5) Output() float32 {
+	return pulseTable[m.pulse1.output()+m.pulse2.output()] + tndTable[m.pcm/2]
+}
@@ -6,11 +6,18 @@ import (
 
 type Mapper7 struct {
 	*Cartridge
-	prgBank int
+	prgBank   int
+	conflicts bool
+}
+
+func init() {
+	RegisterMapper(7, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapper7(nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"AxROM"}})
 }
 
 func NewMapper7(cartridge *Cartridge) Mapper {
-	return &Mapper7{cartridge, 0}
+	return &Mapper7{cartridge, 0, cartridge.BusConflicts(false)}
 }
 
 func (m *Mapper7) Read(address uint16) byte {
@@ -21,8 +28,9 @@ func (m *Mapper7) Read(address uint16) byte {
 		index := m.prgBank*0x8000 + int(address-0x8000)
 		return m.PRG[index]
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		return m.SRAM[index]
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper7 read at address: $%04X", address)
 	}
@@ -32,18 +40,21 @@ func (m *Mapper7) Read(address uint16) byte {
 func (m *Mapper7) Write(address uint16, val byte) {
 	switch {
 	case address < 0x2000:
-		m.CHR[address] = val
+		m.WriteCHR(int(address), val)
 	case address >= 0x8000:
+		if m.conflicts {
+			val &= m.Read(address)
+		}
 		m.prgBank = int(val & 7)
 		switch val & 0x10 {
 		case 0x00:
-			m.Cartridge.Mirror = MirrorSingle0
+			m.SetMirror(MirrorSingle0)
 		case 0x10:
-			m.Cartridge.Mirror = MirrorSingle1
+			m.SetMirror(MirrorSingle1)
 		}
 	case address >= 0x6000:
-		index := int(address) - 0x6000
-		m.SRAM[index] = val
+		m.WriteRAM(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
 	default:
 		log.Fatalf("Illegal mapper7 write at address: $%04X", address)
 	}
@@ -0,0 +1,125 @@
+package nes
+
+import "log"
+
+// Action 53
+// A multicart mapper that can act as NROM, CNROM, BNROM, UNROM or AOROM for
+// each game. $5000-$5FFF picks one of four registers and $8000-$FFFF writes
+// it: $00 the 8KB CHR-RAM bank, $01 the inner PRG bank, $80 the mode
+// (mirroring, PRG banking and game size) and $81 the outer 32KB bank. In the
+// one-screen mirroring modes, bit 4 of $00 and $01 writes picks the screen.
+// At power-on the outer bank is the last one, where the menu is.
+// Ref: http://wiki.nesdev.com/w/index.php/Action_53
+
+// Mirroring of the mode register bits 0 and 1.
+var action53Mirrors = [4]byte{MirrorSingle0, MirrorSingle1, MirrorVertical, MirrorHorizontal}
+
+type MapperAction53 struct {
+	*Cartridge
+	register byte
+	chrBank  int
+	inner    int
+	mode     byte
+	outer    int
+}
+
+func init() {
+	RegisterMapper(28, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperAction53(nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"Action 53"}})
+}
+
+func NewMapperAction53(cartridge *Cartridge) Mapper {
+	m := MapperAction53{Cartridge: cartridge, outer: 0xFF}
+	if m.CHRRAM && len(m.CHR) < 0x8000 {
+		m.CHR = make([]byte, 0x8000)
+	}
+	m.SetMirror(action53Mirrors[m.mode&3])
+	return &m
+}
+
+// prgOffset finds the 16KB bank at address. In the UNROM modes (2 and 3)
+// one half is fixed to the bottom or top of the outer bank, the other is
+// the inner bank. Otherwise both halves are the inner 32KB bank. The inner
+// bank only replaces as many low bits of the outer bank as the game size
+// needs.
+func (m *MapperAction53) prgOffset(address uint16) int {
+	a14 := int(address>>14) & 1
+	prgMode := int(m.mode>>2) & 3
+	outer := m.outer << 1
+	var bank int
+	switch {
+	case prgMode&2 != 0 && a14 == prgMode&1:
+		bank = outer | a14
+	default:
+		inner := m.inner
+		if prgMode&2 == 0 {
+			inner = inner<<1 | a14
+		}
+		mask := 2<<(m.mode>>4&3) - 1
+		bank = outer&^mask | inner&mask
+	}
+	banks := len(m.PRG) / 0x4000
+	return bank%banks*0x4000 + int(address%0x4000)
+}
+
+func (m *MapperAction53) chrOffset(address uint16) int {
+	return (m.chrBank*0x2000 + int(address)) % len(m.CHR)
+}
+
+func (m *MapperAction53) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal Action 53 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperAction53) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0x8000:
+		m.wRegister(val)
+	case address >= 0x6000:
+		m.WriteRAM(address, val)
+	case address >= 0x5000:
+		m.register = val & 0x81
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal Action 53 write at address: $%04X", address)
+	}
+}
+
+func (m *MapperAction53) wRegister(val byte) {
+	switch m.register {
+	case 0x00:
+		m.chrBank = int(val & 3)
+		m.oneScreen(val)
+	case 0x01:
+		m.inner = int(val & 0x0F)
+		m.oneScreen(val)
+	case 0x80:
+		m.mode = val & 0x3F
+	case 0x81:
+		m.outer = int(val)
+	}
+	m.SetMirror(action53Mirrors[m.mode&3])
+}
+
+func (m *MapperAction53) oneScreen(val byte) {
+	if m.mode&2 == 0 {
+		m.mode = m.mode&^1 | val>>4&1
+	}
+}
+
+func (m *MapperAction53) Run() {
+}
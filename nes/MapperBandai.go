@@ -0,0 +1,211 @@
+package nes
+
+import "log"
+
+// Bandai FCG boards
+// Sixteen registers, mirrored every 16 bytes: 0-7 1KB CHR banks, 8 the 16KB
+// PRG bank at $8000 (the last bank is fixed at $C000), 9 mirroring, A-C a
+// 16-bit IRQ counter decremented every CPU cycle, D the serial EEPROM lines.
+// The FCG-1/2 chips (submapper 4) have them at $6000-$7FFF and load the
+// counter directly. The LZ93D50 (submapper 5) has them at $8000-$FFFF,
+// latches the counter until register A is written, and an EEPROM whose
+// data line reads back on bit 4 of $6000-$7FFF. Old headers get both.
+// 159 has an X24C01 instead of a 24C02. 153 has 8KB of SRAM enabled by
+// register D bit 5, CHR-RAM, and bit 0 of CHR registers 0-3 selecting
+// the 256KB half of PRG. 157, the Datach Joint ROM System, has CHR-RAM and
+// a barcode reader on bit 3 of $6000-$7FFF, which never sees a barcode here.
+// What the game writes to the EEPROM is kept in its save file.
+// Ref: http://wiki.nesdev.com/w/index.php/Bandai_FCG_board
+
+type MapperBandai struct {
+	*Cartridge
+	nes       *NES
+	fcg       bool // registers at $6000-$7FFF
+	lz93d50   bool // registers at $8000-$FFFF
+	chrBanks  [8]int
+	prgBank   int
+	prgOuter  int // 153: 256KB half
+	irqEnable bool
+	latch     uint16
+	counter   uint16
+	ramEnable bool // 153
+	eeprom    *eeprom
+}
+
+func init() {
+	newMapperBandai := func(nes *NES) (Mapper, error) {
+		return NewMapperBandai(nes, nes.Cartridge), nil
+	}
+	RegisterMapper(16, AnySubmapper, newMapperBandai, MapperInfo{Boards: []string{"Bandai FCG", "FCG-1", "FCG-2", "LZ93D50"}})
+	RegisterMapper(16, 4, newMapperBandai, MapperInfo{Boards: []string{"FCG-1", "FCG-2"}})
+	RegisterMapper(16, 5, newMapperBandai, MapperInfo{Boards: []string{"LZ93D50"}})
+	RegisterMapper(153, AnySubmapper, newMapperBandai, MapperInfo{Boards: []string{"LZ93D50 with SRAM"}})
+	RegisterMapper(157, AnySubmapper, newMapperBandai, MapperInfo{Boards: []string{"Datach"}})
+	RegisterMapper(159, AnySubmapper, newMapperBandai, MapperInfo{Boards: []string{"LZ93D50 with 24C01"}})
+}
+
+func NewMapperBandai(nes *NES, cartridge *Cartridge) Mapper {
+	m := MapperBandai{Cartridge: cartridge, nes: nes, fcg: true, lz93d50: true}
+	switch cartridge.Mapper {
+	case 16:
+		switch cartridge.Submapper {
+		case 4:
+			m.lz93d50 = false
+		case 5:
+			m.fcg = false
+		}
+		if m.lz93d50 {
+			m.eeprom = newEEPROM(0x100)
+		}
+	case 153:
+		m.fcg = false
+	case 157:
+		m.fcg = false
+		m.eeprom = newEEPROM(0x100)
+	case 159:
+		m.fcg = false
+		m.eeprom = newEEPROM(0x80)
+	}
+	return &m
+}
+
+func (m *MapperBandai) prgOffset(address uint16) int {
+	bank := m.prgBank
+	if address >= 0xC000 {
+		bank = 0x0F
+	}
+	bank |= m.prgOuter << 4
+	banks := len(m.PRG) / 0x4000
+	return bank%banks*0x4000 + int(address%0x4000)
+}
+
+func (m *MapperBandai) chrOffset(address uint16) int {
+	if m.CHRRAM {
+		return int(address) % len(m.CHR)
+	}
+	return (m.chrBanks[address/0x0400]*0x0400 + int(address%0x0400)) % len(m.CHR)
+}
+
+func (m *MapperBandai) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		return m.rLow(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal Bandai read at address: $%04X", address)
+	}
+	return 0
+}
+
+// rLow reads $6000-$7FFF: the SRAM of 153, or the EEPROM data line.
+func (m *MapperBandai) rLow(address uint16) byte {
+	if m.Mapper == 153 {
+		if !m.ramEnable {
+			return 0
+		}
+		return m.ReadRAM(address)
+	}
+	if m.eeprom != nil && m.eeprom.read() {
+		return 0x10
+	}
+	return 0
+}
+
+func (m *MapperBandai) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0x8000:
+		if m.lz93d50 {
+			m.wRegister(address, val)
+		}
+	case address >= 0x6000:
+		if m.Mapper == 153 {
+			if m.ramEnable {
+				m.WriteRAM(address, val)
+			}
+		} else if m.fcg {
+			m.wRegister(address, val)
+		}
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal Bandai write at address: $%04X", address)
+	}
+}
+
+func (m *MapperBandai) wRegister(address uint16, val byte) {
+	switch r := address & 0x0F; {
+	case r < 8:
+		m.chrBanks[r] = int(val)
+		if m.Mapper == 153 && r < 4 {
+			m.prgOuter = int(val & 1)
+		}
+	case r == 8:
+		m.prgBank = int(val & 0x0F)
+	case r == 9:
+		switch val & 3 {
+		case 0:
+			m.SetMirror(MirrorVertical)
+		case 1:
+			m.SetMirror(MirrorHorizontal)
+		case 2:
+			m.SetMirror(MirrorSingle0)
+		case 3:
+			m.SetMirror(MirrorSingle1)
+		}
+	case r == 0x0A:
+		m.irqEnable = val&1 != 0
+		if m.lz93d50 {
+			m.counter = m.latch
+		}
+		m.nes.CPU.SetIRQ(IRQMapper, false)
+	case r == 0x0B:
+		m.latch = m.latch&0xFF00 | uint16(val)
+		if m.fcg {
+			m.counter = m.counter&0xFF00 | uint16(val)
+		}
+	case r == 0x0C:
+		m.latch = m.latch&0x00FF | uint16(val)<<8
+		if m.fcg {
+			m.counter = m.counter&0x00FF | uint16(val)<<8
+		}
+	case r == 0x0D:
+		m.ramEnable = val&0x20 != 0
+		if m.eeprom != nil {
+			m.eeprom.write(val&0x20 != 0, val&0x40 != 0)
+		}
+	}
+}
+
+// Tick raises the IRQ when the counter is 0, as it wraps around.
+func (m *MapperBandai) Tick() {
+	if !m.irqEnable {
+		return
+	}
+	if m.counter == 0 {
+		m.nes.CPU.SetIRQ(IRQMapper, true)
+	}
+	m.counter--
+}
+
+func (m *MapperBandai) Run() {
+}
+
+func (m *MapperBandai) LoadSave(data []byte) error {
+	if m.eeprom == nil {
+		return nil
+	}
+	return m.eeprom.load(data)
+}
+
+func (m *MapperBandai) Save() []byte {
+	if m.eeprom == nil {
+		return nil
+	}
+	return m.eeprom.save()
+}
@@ -0,0 +1,222 @@
+package nes
+
+import (
+	"log"
+	"strings"
+)
+
+// Discrete logic boards
+// A latch or two of 74-series chips selecting the PRG and CHR banks, and on
+// a few boards the mirroring. They differ in where the latch sits and which
+// bits go where. Latches in the ROM space see the ROM drive the bus too, and
+// on most boards the written value is ANDed with the ROM byte.
+// All banks are 0 at power-on, except those that are fixed.
+// Ref: http://wiki.nesdev.com/w/index.php/GxROM
+// Ref: http://wiki.nesdev.com/w/index.php/Color_Dreams
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_034
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_071
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_232
+// Ref: http://wiki.nesdev.com/w/index.php/NINA-003-006
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_113
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_140
+
+var discreteBoards = []struct {
+	mapper, submapper int
+	boards            []string
+	conflicts         bool // by default, see Cartridge.BusConflicts
+}{
+	{11, AnySubmapper, []string{"Color Dreams"}, true},
+	{34, AnySubmapper, []string{"BNROM", "NINA-001"}, true},
+	{34, 1, []string{"NINA-001"}, false},
+	{34, 2, []string{"BNROM"}, true},
+	{66, AnySubmapper, []string{"GxROM", "GNROM", "MHROM"}, true},
+	{71, AnySubmapper, []string{"Camerica/Codemasters", "BF9093"}, false},
+	{71, 1, []string{"BF9097"}, false},
+	{79, AnySubmapper, []string{"NINA-03", "NINA-06"}, false},
+	{113, AnySubmapper, []string{"NINA-03/NINA-06 (AVE)"}, false},
+	{140, AnySubmapper, []string{"JF-11", "JF-14"}, false},
+	{232, AnySubmapper, []string{"Camerica Quattro", "BF9096"}, false},
+	{232, 1, []string{"Aladdin Deck Enhancer"}, false},
+}
+
+type MapperDiscrete struct {
+	*Cartridge
+	prgBanks  [2]int // 16KB at $8000 and $C000
+	chrBanks  [2]int // 4KB at $0000 and $1000
+	conflicts bool
+	nina001   bool // mapper 34 on the NINA-001 board
+}
+
+func init() {
+	for _, b := range discreteBoards {
+		b := b
+		RegisterMapper(b.mapper, b.submapper, func(nes *NES) (Mapper, error) {
+			return NewMapperDiscrete(nes.Cartridge, b.conflicts), nil
+		}, MapperInfo{Boards: b.boards})
+	}
+}
+
+// NewMapperDiscrete builds the board of the cartridge mapper number. Mapper
+// 34 without submapper is NINA-001 if the database says so or it has more
+// than 8KB of CHR, BNROM otherwise.
+func NewMapperDiscrete(cartridge *Cartridge, conflicts bool) Mapper {
+	m := MapperDiscrete{Cartridge: cartridge}
+	m.setPRG32(0)
+	m.setCHR8(0)
+	switch cartridge.Mapper {
+	case 34:
+		switch cartridge.Submapper {
+		case 1:
+			m.nina001 = true
+		case 2:
+		default:
+			m.nina001 = strings.Contains(cartridge.Board, "NINA") ||
+				!strings.Contains(cartridge.Board, "BNROM") && len(cartridge.CHR) > 0x2000
+		}
+		if m.nina001 {
+			conflicts = false
+		}
+	case 71:
+		m.prgBanks[1] = len(m.PRG)/0x4000 - 1
+	case 232:
+		m.prgBanks[1] = 3
+	}
+	m.conflicts = cartridge.BusConflicts(conflicts)
+	return &m
+}
+
+func (m *MapperDiscrete) setPRG32(bank int) {
+	m.prgBanks = [2]int{bank * 2, bank*2 + 1}
+}
+
+func (m *MapperDiscrete) setCHR8(bank int) {
+	m.chrBanks = [2]int{bank * 2, bank*2 + 1}
+}
+
+func (m *MapperDiscrete) prgOffset(address uint16) int {
+	bank := m.prgBanks[(address-0x8000)/0x4000]
+	return (bank*0x4000 + int(address%0x4000)) % len(m.PRG)
+}
+
+func (m *MapperDiscrete) chrOffset(address uint16) int {
+	bank := m.chrBanks[address/0x1000]
+	return (bank*0x1000 + int(address%0x1000)) % len(m.CHR)
+}
+
+func (m *MapperDiscrete) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal discrete mapper read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperDiscrete) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0x8000:
+		if m.conflicts {
+			val &= m.Read(address)
+		}
+		m.wROM(address, val)
+	case address >= 0x6000:
+		m.wRAM(address, val)
+	case address >= 0x4020:
+		// NINA-03/06: $4100-$5FFF, A8 high
+		if address&0xE100 == 0x4100 {
+			m.wLow(val)
+		}
+	default:
+		log.Fatalf("Illegal discrete mapper write at address: $%04X", address)
+	}
+}
+
+// wROM is a write to a latch in $8000-$FFFF.
+func (m *MapperDiscrete) wROM(address uint16, val byte) {
+	switch m.Mapper {
+	case 11:
+		m.setPRG32(int(val & 3))
+		m.setCHR8(int(val >> 4))
+	case 34:
+		if !m.nina001 {
+			m.setPRG32(int(val))
+		}
+	case 66:
+		m.setPRG32(int(val >> 4 & 3))
+		m.setCHR8(int(val & 3))
+	case 71:
+		// Fire Hawk's BF9097 has a one-screen mirroring control at
+		// $9000-$9FFF. No other game writes there, so every board has it.
+		switch {
+		case address >= 0xC000:
+			m.prgBanks[0] = int(val)
+		case address >= 0x9000 && address < 0xA000:
+			if val&0x10 == 0 {
+				m.SetMirror(MirrorSingle0)
+			} else {
+				m.SetMirror(MirrorSingle1)
+			}
+		}
+	case 232:
+		block, page := m.prgBanks[1]&^3, m.prgBanks[0]&3
+		switch {
+		case address >= 0xC000:
+			page = int(val & 3)
+		case m.Submapper == 1: // Aladdin Deck Enhancer: bits 3 and 4 swapped
+			block = int(val>>4&1|val>>2&2) * 4
+		default:
+			block = int(val>>3&3) * 4
+		}
+		m.prgBanks = [2]int{block | page, block | 3}
+	}
+}
+
+// wRAM is a write to $6000-$7FFF, which goes to the PRG-RAM too on
+// NINA-001.
+func (m *MapperDiscrete) wRAM(address uint16, val byte) {
+	switch {
+	case m.Mapper == 140:
+		m.setPRG32(int(val >> 4 & 3))
+		m.setCHR8(int(val & 0x0F))
+		return
+	case m.nina001:
+		switch address {
+		case 0x7FFD:
+			m.setPRG32(int(val & 1))
+		case 0x7FFE:
+			m.chrBanks[0] = int(val & 0x0F)
+		case 0x7FFF:
+			m.chrBanks[1] = int(val & 0x0F)
+		}
+	}
+	m.WriteRAM(address, val)
+}
+
+// wLow is a write to the NINA-03/06 latch.
+func (m *MapperDiscrete) wLow(val byte) {
+	switch m.Mapper {
+	case 79:
+		m.setPRG32(int(val >> 3 & 1))
+		m.setCHR8(int(val & 7))
+	case 113:
+		m.setPRG32(int(val >> 3 & 7))
+		m.setCHR8(int(val&7 | val>>3&8))
+		if val&0x80 == 0 {
+			m.SetMirror(MirrorHorizontal)
+		} else {
+			m.SetMirror(MirrorVertical)
+		}
+	}
+}
+
+func (m *MapperDiscrete) Run() {
+}
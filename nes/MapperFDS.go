@@ -0,0 +1,318 @@
+package nes
+
+import "log"
+
+// Famicom Disk System RAM adapter
+// 32KB of PRG-RAM at $6000-$DFFF, the BIOS at $E000, 8KB of CHR-RAM, a timer
+// IRQ, the disk drive and the audio channel, with their registers at
+// $4020-$4092.
+// The drive moves one byte between the disk and the adapter every 149 CPU
+// cycles, about 96.4Kbit/s, and raises an IRQ for each one if asked to.
+// After the motor starts, the head takes a while to get back to the start
+// of the side, and stops at its end.
+// Ref: http://wiki.nesdev.com/w/index.php/Family_Computer_Disk_System
+
+const (
+	fdsByteCycles   = 149
+	fdsRewindCycles = 50000
+	// A disk that was changed reads as ejected for about a second, so the
+	// BIOS notices.
+	fdsInsertCycles = CPUFrequency
+)
+
+// Disk transfer IRQ source, beside the timer's IRQMapper.
+const irqDisk = IRQExpansion
+
+type MapperFDS struct {
+	*Cartridge
+	nes         *NES
+	fds         *FDS
+	side        int
+	ejected     bool
+	insertDelay int
+	// $4020-$4023
+	timerReload  uint16
+	timer        uint16
+	timerRepeat  bool
+	timerEnable  bool
+	diskEnable   bool
+	timerPending bool
+	// $4024-$4025
+	writeData   byte
+	motorOn     bool
+	resetHead   bool
+	readMode    bool
+	crcControl  bool
+	crcLast     bool // crcControl of the last byte
+	dataReady   bool // $4025 bit 6, past the gap
+	diskIRQ     bool
+	transferred bool
+	// Drive
+	readData  byte
+	position  int
+	delay     int
+	endOfHead bool
+	scanning  bool
+	gapEnded  bool
+	crc       uint16
+	audio     fdsAudio
+}
+
+func NewMapperFDS(nes *NES, fds *FDS) Mapper {
+	return &MapperFDS{Cartridge: nes.Cartridge, nes: nes, fds: fds, audio: newFDSAudio(), endOfHead: true}
+}
+
+func (m *MapperFDS) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[address]
+	case address >= 0xE000:
+		return m.PRG[address-0xE000]
+	case address >= 0x6000:
+		return m.SRAM[address-0x6000]
+	case address == 0x4030:
+		return m.rStatus()
+	case address == 0x4031:
+		m.transferred = false
+		m.nes.CPU.SetIRQ(irqDisk, false)
+		return m.readData
+	case address == 0x4032:
+		return m.rDrive()
+	case address == 0x4033:
+		return 0x80 // battery good
+	case address >= 0x4040 && address < 0x4100:
+		return m.audio.read(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal FDS read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperFDS) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.CHR[address] = val
+	case address >= 0xE000: // BIOS
+	case address >= 0x6000:
+		m.SRAM[address-0x6000] = val
+	case address == 0x4020:
+		m.timerReload = m.timerReload&0xFF00 | uint16(val)
+	case address == 0x4021:
+		m.timerReload = m.timerReload&0x00FF | uint16(val)<<8
+	case address == 0x4022:
+		m.timerRepeat = val&1 != 0
+		m.timerEnable = val&2 != 0 && m.diskEnable
+		if m.timerEnable {
+			m.timer = m.timerReload
+		} else {
+			m.ackTimer()
+		}
+	case address == 0x4023:
+		m.diskEnable = val&1 != 0
+		m.audio.enabled = val&2 != 0
+		if !m.diskEnable {
+			m.timerEnable = false
+			m.ackTimer()
+		}
+	case address == 0x4024:
+		if m.diskEnable {
+			m.writeData = val
+			m.transferred = false
+			m.nes.CPU.SetIRQ(irqDisk, false)
+		}
+	case address == 0x4025:
+		if m.diskEnable {
+			m.wControl(val)
+		}
+	case address >= 0x4040 && address < 0x4100:
+		m.audio.write(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal FDS write at address: $%04X", address)
+	}
+}
+
+func (m *MapperFDS) ackTimer() {
+	m.timerPending = false
+	m.nes.CPU.SetIRQ(IRQMapper, false)
+}
+
+// $4025: motor, head reset, read or write, mirroring, CRC, start of data and
+// transfer IRQ.
+func (m *MapperFDS) wControl(val byte) {
+	m.motorOn = val&0x01 != 0
+	m.resetHead = val&0x02 != 0
+	m.readMode = val&0x04 != 0
+	if val&0x08 != 0 {
+		m.SetMirror(MirrorHorizontal)
+	} else {
+		m.SetMirror(MirrorVertical)
+	}
+	m.crcControl = val&0x10 != 0
+	m.dataReady = val&0x40 != 0
+	m.diskIRQ = val&0x80 != 0
+	m.nes.CPU.SetIRQ(irqDisk, false)
+}
+
+// $4030: timer IRQ, byte transferred, end of head. Reading acknowledges the
+// IRQs.
+func (m *MapperFDS) rStatus() byte {
+	var val byte
+	if m.timerPending {
+		val |= 0x01
+	}
+	if m.transferred {
+		val |= 0x02
+	}
+	if m.endOfHead {
+		val |= 0x40
+	}
+	m.transferred = false
+	m.ackTimer()
+	m.nes.CPU.SetIRQ(irqDisk, false)
+	return val
+}
+
+// $4032: no disk, not ready, write protected.
+func (m *MapperFDS) rDrive() byte {
+	val := byte(0x40)
+	if !m.inserted() {
+		val |= 0x05
+	}
+	if !m.inserted() || !m.scanning {
+		val |= 0x02
+	}
+	return val
+}
+
+func (m *MapperFDS) inserted() bool {
+	return !m.ejected && m.insertDelay == 0
+}
+
+func (m *MapperFDS) Tick() {
+	if m.timerEnable {
+		if m.timer == 0 {
+			m.timerPending = true
+			m.nes.CPU.SetIRQ(IRQMapper, true)
+			m.timer = m.timerReload
+			if !m.timerRepeat {
+				m.timerEnable = false
+			}
+		} else {
+			m.timer--
+		}
+	}
+	m.tickDrive()
+	m.audio.tick()
+}
+
+func (m *MapperFDS) tickDrive() {
+	if m.insertDelay > 0 {
+		m.insertDelay--
+		return
+	}
+	if m.ejected || !m.motorOn {
+		m.endOfHead = true
+		m.scanning = false
+		return
+	}
+	if m.resetHead && !m.scanning {
+		return
+	}
+	if m.endOfHead {
+		m.delay = fdsRewindCycles
+		m.endOfHead = false
+		m.position = 0
+		m.gapEnded = false
+		return
+	}
+	if m.delay > 0 {
+		m.delay--
+		return
+	}
+	m.scanning = true
+	disk := m.fds.disks[m.side]
+	irq := m.diskIRQ
+	if m.readMode {
+		data := disk[m.position]
+		if !m.dataReady {
+			m.gapEnded = false
+		} else if data != 0 && !m.gapEnded {
+			// The start mark ends the gap, without an IRQ.
+			m.gapEnded = true
+			irq = false
+		}
+		if m.gapEnded {
+			m.transferred = true
+			m.readData = data
+			if irq {
+				m.nes.CPU.SetIRQ(irqDisk, true)
+			}
+		}
+	} else {
+		data := m.writeData
+		if !m.crcControl {
+			m.transferred = true
+			if irq {
+				m.nes.CPU.SetIRQ(irqDisk, true)
+			}
+		}
+		if !m.dataReady {
+			data = 0
+			m.crc = 0
+		}
+		if !m.crcControl {
+			m.crc = fdsCRC(m.crc, data)
+		} else {
+			if !m.crcLast {
+				m.crc = fdsCRC(fdsCRC(m.crc, 0), 0)
+			}
+			data = byte(m.crc)
+			m.crc >>= 8
+		}
+		disk[m.position] = data
+		m.fds.written[m.side] = true
+		m.gapEnded = false
+	}
+	m.crcLast = m.crcControl
+	m.position++
+	if m.position >= len(disk) {
+		m.motorOn = false
+	} else {
+		m.delay = fdsByteCycles
+	}
+}
+
+// Eject takes the disk out, or puts the same side back in.
+func (m *MapperFDS) Eject() {
+	m.ejected = !m.ejected
+	if !m.ejected {
+		m.insertDelay = fdsInsertCycles
+	}
+}
+
+// NextSide puts in the next disk side, wrapping around to the first.
+func (m *MapperFDS) NextSide() {
+	m.side = (m.side + 1) % m.fds.Sides()
+	m.ejected = false
+	m.insertDelay = fdsInsertCycles
+}
+
+func (m *MapperFDS) Run() {
+}
+
+// Output is the FDS audio. fdsAudio.output is 0 to 1, mixed at twice the
+// level of an APU pulse at full volume: 0 to 2*pulseTable[15].
+func (m *MapperFDS) Output() float32 {
+	return m.audio.output() * pulseTable[15] * 2
+}
+
+func (m *MapperFDS) ChannelNames() []string {
+	return FDSChannels
+}
+
+func (m *MapperFDS) Mute(channel int, muted bool) {
+	m.audio.muted = muted
+}
@@ -0,0 +1,309 @@
+package nes
+
+import (
+	"log"
+	"math"
+)
+
+// Sunsoft FME-7 and 5B
+// A command register at $8000 picks which of 16 registers the parameter at
+// $A000 goes to: CHR and PRG banks, RAM or ROM at $6000, mirroring and a
+// 16-bit IRQ counter clocked by the CPU. The 5B adds a YM2149F (AY-3-8910)
+// with its registers at $C000 and $E000.
+// Ref: http://wiki.nesdev.com/w/index.php/Sunsoft_FME-7
+// Ref: http://wiki.nesdev.com/w/index.php/Sunsoft_5B_audio
+
+// Sunsoft5BChannels names the 5B audio channels, for APU.Mute.
+var Sunsoft5BChannels = []string{"5b-a", "5b-b", "5b-c"}
+
+// The 5B DAC has 32 levels 1.5dB apart, level 0 silent. Volumes use the odd
+// levels and the envelope all of them.
+var sunsoft5BLevels [32]float32
+
+func init() {
+	for i := 1; i < 32; i++ {
+		sunsoft5BLevels[i] = float32(math.Pow(10, float64(i-31)*1.5/20))
+	}
+}
+
+type sunsoft5B struct {
+	register  byte
+	periods   [3]int
+	counters  [3]int
+	tones     [3]bool // square wave outputs
+	volumes   [3]byte // bit 4: use the envelope
+	mixer     byte    // tone and noise disable bits
+	noise     int     // noise period
+	noiseCnt  int
+	lfsr      int
+	envPeriod int
+	envCnt    int
+	envShape  byte
+	envStep   byte
+	envMask   byte // $1F while the envelope falls
+	envHold   bool
+	cycles    int
+	muted     [3]bool
+}
+
+func (s *sunsoft5B) write(val byte) {
+	switch r := s.register; {
+	case r < 6:
+		i := r / 2
+		if r%2 == 0 {
+			s.periods[i] = s.periods[i]&0xF00 | int(val)
+		} else {
+			s.periods[i] = s.periods[i]&0x0FF | int(val&0x0F)<<8
+		}
+	case r == 6:
+		s.noise = int(val & 0x1F)
+	case r == 7:
+		s.mixer = val
+	case r < 11:
+		s.volumes[r-8] = val & 0x1F
+	case r == 11:
+		s.envPeriod = s.envPeriod&0xFF00 | int(val)
+	case r == 12:
+		s.envPeriod = s.envPeriod&0x00FF | int(val)<<8
+	case r == 13:
+		s.envShape = val & 0x0F
+		s.envStep = 0
+		s.envHold = false
+		s.envMask = 0x1F
+		if s.envShape&4 != 0 {
+			s.envMask = 0
+		}
+	}
+}
+
+// tick runs one CPU cycle. The chip divides the CPU clock by 2, then by 8
+// for tones and the envelope: tones flip and the envelope steps every 16
+// times their period in CPU cycles, so a 32-step ramp takes 512 times its
+// period. The noise steps every 32 times its period.
+func (s *sunsoft5B) tick() {
+	s.cycles++
+	if s.cycles%16 != 0 {
+		return
+	}
+	s.envCnt++
+	if s.envCnt >= s.envPeriod {
+		s.envCnt = 0
+		s.stepEnvelope()
+	}
+	for i := range s.counters {
+		s.counters[i]++
+		if s.counters[i] >= s.periods[i] {
+			s.counters[i] = 0
+			s.tones[i] = !s.tones[i]
+		}
+	}
+	if s.cycles%32 != 0 {
+		return
+	}
+	s.cycles = 0
+	s.noiseCnt++
+	if s.noiseCnt >= s.noise {
+		s.noiseCnt = 0
+		if s.lfsr == 0 {
+			s.lfsr = 1
+		}
+		bit := (s.lfsr ^ s.lfsr>>3) & 1
+		s.lfsr = s.lfsr>>1 | bit<<16
+	}
+}
+
+// stepEnvelope moves the envelope by one step. At the end of a ramp it holds,
+// starts over, or turns around, following the shape bits: continue, attack,
+// alternate and hold.
+func (s *sunsoft5B) stepEnvelope() {
+	if s.envHold {
+		return
+	}
+	s.envStep++
+	if s.envStep < 32 {
+		return
+	}
+	switch {
+	case s.envShape&8 == 0:
+		s.envHold = true
+		s.envStep = 0
+		s.envMask = 0
+	case s.envShape&1 != 0:
+		s.envHold = true
+		s.envStep = 31
+		if s.envShape&2 != 0 {
+			s.envMask ^= 0x1F
+		}
+	default:
+		s.envStep = 0
+		if s.envShape&2 != 0 {
+			s.envMask ^= 0x1F
+		}
+	}
+}
+
+// output is the sum of the channels, 0 to 1 for each.
+func (s *sunsoft5B) output() float32 {
+	var out float32
+	noise := s.lfsr&1 != 0
+	for i := range s.tones {
+		tone := s.tones[i] || s.mixer&(1<<uint(i)) != 0
+		noiseOn := noise || s.mixer&(8<<uint(i)) != 0
+		if !tone || !noiseOn || s.muted[i] {
+			continue
+		}
+		level := s.volumes[i]&0x0F*2 + 1
+		if s.volumes[i]&0x0F == 0 {
+			level = 0
+		}
+		if s.volumes[i]&0x10 != 0 {
+			level = s.envStep ^ s.envMask
+		}
+		out += sunsoft5BLevels[level]
+	}
+	return out
+}
+
+type MapperFME7 struct {
+	*Cartridge
+	nes       *NES
+	command   byte
+	chrBanks  [8]int
+	prgBanks  [4]byte // $6000, $8000, $A000, $C000
+	ramSelect bool
+	ramEnable bool
+	irqEnable bool
+	countIRQ  bool
+	counter   uint16
+	audio     sunsoft5B
+}
+
+func init() {
+	RegisterMapper(69, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperFME7(nes, nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"JLROM", "JSROM", "BTR", "FME-7", "5B"}})
+}
+
+func NewMapperFME7(nes *NES, cartridge *Cartridge) Mapper {
+	return &MapperFME7{Cartridge: cartridge, nes: nes}
+}
+
+func (m *MapperFME7) prgOffset(address uint16) int {
+	banks := len(m.PRG) / 0x2000
+	bank := banks - 1
+	if i := int(address-0x6000) / 0x2000; i < 4 {
+		bank = int(m.prgBanks[i])
+	}
+	return bank%banks*0x2000 + int(address%0x2000)
+}
+
+func (m *MapperFME7) chrOffset(address uint16) int {
+	return (m.chrBanks[address/0x0400]*0x0400 + int(address%0x0400)) % len(m.CHR)
+}
+
+func (m *MapperFME7) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		if !m.ramSelect {
+			return m.PRG[m.prgOffset(address)]
+		}
+		if !m.ramEnable {
+			return 0
+		}
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal FME-7 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperFME7) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0xE000:
+		m.audio.write(val)
+	case address >= 0xC000:
+		m.audio.register = val
+		if val&0xF0 != 0 {
+			m.audio.register = 0xFF // disables the writes
+		}
+	case address >= 0xA000:
+		m.wParameter(val)
+	case address >= 0x8000:
+		m.command = val & 0x0F
+	case address >= 0x6000:
+		if m.ramSelect && m.ramEnable {
+			m.WriteRAM(address, val)
+		}
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal FME-7 write at address: $%04X", address)
+	}
+}
+
+func (m *MapperFME7) wParameter(val byte) {
+	switch c := m.command; {
+	case c < 8:
+		m.chrBanks[c] = int(val)
+	case c == 8:
+		m.prgBanks[0] = val & 0x3F
+		m.ramSelect = val&0x40 != 0
+		m.ramEnable = val&0x80 != 0
+	case c < 12:
+		m.prgBanks[c-8] = val & 0x3F
+	case c == 12:
+		switch val & 3 {
+		case 0:
+			m.SetMirror(MirrorVertical)
+		case 1:
+			m.SetMirror(MirrorHorizontal)
+		case 2:
+			m.SetMirror(MirrorSingle0)
+		case 3:
+			m.SetMirror(MirrorSingle1)
+		}
+	case c == 13:
+		m.irqEnable = val&1 != 0
+		m.countIRQ = val&0x80 != 0
+		m.nes.CPU.SetIRQ(IRQMapper, false)
+	case c == 14:
+		m.counter = m.counter&0xFF00 | uint16(val)
+	case c == 15:
+		m.counter = m.counter&0x00FF | uint16(val)<<8
+	}
+}
+
+func (m *MapperFME7) Tick() {
+	if m.countIRQ {
+		m.counter--
+		if m.counter == 0xFFFF && m.irqEnable {
+			m.nes.CPU.SetIRQ(IRQMapper, true)
+		}
+	}
+	m.audio.tick()
+}
+
+func (m *MapperFME7) Run() {
+}
+
+// Output mixes the 5B channels. Each is 0 to 1 from the DAC levels and is
+// mixed at twice the level of an APU pulse at full volume, 0 to
+// 2*pulseTable[15], so the three together reach six times that.
+func (m *MapperFME7) Output() float32 {
+	return m.audio.output() * pulseTable[15] * 2
+}
+
+func (m *MapperFME7) ChannelNames() []string {
+	return Sunsoft5BChannels
+}
+
+func (m *MapperFME7) Mute(channel int, muted bool) {
+	m.audio.muted[channel] = muted
+}
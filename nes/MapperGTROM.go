@@ -0,0 +1,84 @@
+package nes
+
+import "log"
+
+// GTROM (Cheapocabra)
+// 512KB of self-flashable PRG in 32KB banks and 32KB of RAM for the PPU:
+// two 8KB pages of CHR and two 8KB pages of four-screen nametables. The
+// register at $5000-$5FFF and $7000-$7FFF picks the PRG bank (bits 0-3), the
+// CHR page (bit 4) and the nametable page (bit 5); bits 6 and 7 light LEDs.
+// PRG is an SST39SF040 written through $8000-$FFFF, kept in the save file.
+// Ref: http://wiki.nesdev.com/w/index.php/GTROM
+
+type MapperGTROM struct {
+	*Cartridge
+	prgBank int
+	chrBank int
+	flash   sstFlash
+}
+
+func init() {
+	RegisterMapper(111, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperGTROM(nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"GTROM"}})
+}
+
+func NewMapperGTROM(cartridge *Cartridge) Mapper {
+	m := MapperGTROM{Cartridge: cartridge, flash: sstFlash{data: cartridge.PRG}}
+	m.CHR = make([]byte, 0x8000)
+	m.CHRRAM = true
+	m.wRegister(0)
+	return &m
+}
+
+func (m *MapperGTROM) prgOffset(address uint16) int {
+	return (m.prgBank*0x8000 + int(address-0x8000)) % len(m.PRG)
+}
+
+func (m *MapperGTROM) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrBank*0x2000+int(address)]
+	case address >= 0x8000:
+		return m.flash.read(m.prgOffset(address))
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal GTROM read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperGTROM) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.CHR[m.chrBank*0x2000+int(address)] = val
+	case address >= 0x8000:
+		m.flash.write(m.prgOffset(address), val)
+	case address >= 0x7000 || address >= 0x5000 && address < 0x6000:
+		m.wRegister(val)
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal GTROM write at address: $%04X", address)
+	}
+}
+
+func (m *MapperGTROM) wRegister(val byte) {
+	m.prgBank = int(val & 0x0F)
+	m.chrBank = int(val >> 4 & 1)
+	page := 0x4000 + int(val>>5&1)*0x2000
+	for slot := 0; slot < 4; slot++ {
+		m.MapNameTable(slot, m.CHR[page+slot*0x0400:], true)
+	}
+}
+
+func (m *MapperGTROM) Run() {
+}
+
+func (m *MapperGTROM) LoadSave(data []byte) error {
+	return m.flash.load(data)
+}
+
+func (m *MapperGTROM) Save() []byte {
+	return m.flash.save()
+}
@@ -0,0 +1,117 @@
+package nes
+
+import "log"
+
+// MMC2 and MMC4
+// Each 4KB half of the pattern tables has two CHR banks, one for tile $FD and
+// one for tile $FE, and a latch choosing between them. The PPU reading the
+// high plane of tile $FD or $FE flips the latch of that half, after the
+// read, so the tile itself still comes from the old bank. The MMC2 only
+// watches row 0 of the tiles of the left half, the MMC4 every row.
+// PRG is 8KB switchable and 24KB fixed on the MMC2, 16KB and 16KB on the
+// MMC4, which has 8KB of PRG-RAM too.
+// Ref: http://wiki.nesdev.com/w/index.php/MMC2
+// Ref: http://wiki.nesdev.com/w/index.php/MMC4
+
+type MapperMMC2 struct {
+	*Cartridge
+	mmc4     bool
+	prgBank  int
+	chrBanks [2][2]int // [half][latch]: $FD, $FE
+	latches  [2]int
+}
+
+func init() {
+	RegisterMapper(9, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperMMC2(nes.Cartridge, false), nil
+	}, MapperInfo{Boards: []string{"PxROM", "PNROM", "PEEOROM", "MMC2"}, PPUHooks: true})
+	RegisterMapper(10, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperMMC2(nes.Cartridge, true), nil
+	}, MapperInfo{Boards: []string{"FxROM", "FJROM", "FKROM", "MMC4"}, PPUHooks: true})
+}
+
+// NewMapperMMC2 builds an MMC2, or an MMC4 if mmc4 is set. Both latches
+// start on $FE.
+func NewMapperMMC2(cartridge *Cartridge, mmc4 bool) Mapper {
+	return &MapperMMC2{Cartridge: cartridge, mmc4: mmc4, latches: [2]int{1, 1}}
+}
+
+func (m *MapperMMC2) prgOffset(address uint16) int {
+	size := 0x2000
+	if m.mmc4 {
+		size = 0x4000
+	}
+	banks := len(m.PRG) / size
+	i := int(address-0x8000) / size
+	bank := m.prgBank
+	if i > 0 { // the fixed banks are the last ones
+		bank = banks - 0x8000/size + i
+	}
+	return bank%banks*size + int(address)%size
+}
+
+func (m *MapperMMC2) chrOffset(address uint16) int {
+	half := address / 0x1000
+	bank := m.chrBanks[half][m.latches[half]]
+	return (bank*0x1000 + int(address%0x1000)) % len(m.CHR)
+}
+
+// latch flips the latch of a pattern table half after the PPU read address.
+func (m *MapperMMC2) latch(address uint16) {
+	half := address / 0x1000
+	tile := address & 0x0FF8
+	if half == 0 && !m.mmc4 && address&7 != 0 {
+		return
+	}
+	switch tile {
+	case 0x0FD8:
+		m.latches[half] = 0
+	case 0x0FE8:
+		m.latches[half] = 1
+	}
+}
+
+func (m *MapperMMC2) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		val := m.CHR[m.chrOffset(address)]
+		m.latch(address)
+		return val
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal MMC2 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperMMC2) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0xF000:
+		if val&1 == 0 {
+			m.SetMirror(MirrorVertical)
+		} else {
+			m.SetMirror(MirrorHorizontal)
+		}
+	case address >= 0xB000:
+		i := int(address-0xB000) / 0x1000
+		m.chrBanks[i/2][i%2] = int(val & 0x1F)
+	case address >= 0xA000:
+		m.prgBank = int(val & 0x0F)
+	case address >= 0x8000:
+	case address >= 0x6000:
+		m.WriteRAM(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal MMC2 write at address: $%04X", address)
+	}
+}
+
+func (m *MapperMMC2) Run() {
+}
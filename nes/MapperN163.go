@@ -0,0 +1,229 @@
+package nes
+
+import "log"
+
+// Namco 163
+// Eight 1KB CHR banks and four nametable banks that pick CHR-ROM or CIRAM,
+// a 15-bit IRQ counter clocked by the CPU, and 128 bytes of RAM inside the
+// chip. The RAM holds the waveforms and registers of up to 8 wavetable
+// channels, and is battery-backed when the header gives exactly 128 bytes
+// of PRG-NVRAM.
+// Ref: http://wiki.nesdev.com/w/index.php/Namco_163
+// Ref: http://wiki.nesdev.com/w/index.php/Namco_163_audio
+
+// CPU cycles to update one wavetable channel.
+const n163Cycles = 15
+
+// N163Channels names the Namco 163 audio channels, for APU.Mute.
+var N163Channels = []string{"n163-1", "n163-2", "n163-3", "n163-4", "n163-5", "n163-6", "n163-7", "n163-8"}
+
+type MapperN163 struct {
+	*Cartridge
+	nes       *NES
+	ram       []byte // internal RAM
+	chipNVRAM bool   // ram is the cartridge's PRG-NVRAM, there is no PRG-RAM
+	address   byte   // $F800 data port address and auto-increment
+	prgBanks  [3]byte
+	chrBanks  [12]byte // $0000-$1FFF in 1KB, then the nametables
+	noCIRAM   byte     // $E800 bits 6-7: no CIRAM at $0000-$0FFF, $1000-$1FFF
+	protect   byte     // $F800 PRG-RAM write protection
+	counter   uint16   // IRQ counter, bit 15 enables it
+	noSound   bool     // board without audio
+	soundOff  bool     // $E000 bit 6
+	cycles    int
+	channel   int // updated last
+	out       [8]int
+	muted     [8]bool
+}
+
+func init() {
+	RegisterMapper(19, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperN163(nes, nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"Namco 163"}})
+	RegisterMapper(19, 2, func(nes *NES) (Mapper, error) {
+		return NewMapperN163(nes, nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"Namco 163 (no audio)"}})
+}
+
+func NewMapperN163(nes *NES, cartridge *Cartridge) Mapper {
+	m := MapperN163{Cartridge: cartridge, nes: nes, ram: make([]byte, 0x80)}
+	if len(cartridge.SRAM) == 0x80 {
+		m.ram = cartridge.SRAM
+		m.chipNVRAM = true
+	}
+	m.noSound = cartridge.Submapper == 2
+	m.channel = 7
+	return &m
+}
+
+func (m *MapperN163) prgOffset(address uint16) int {
+	banks := len(m.PRG) / 0x2000
+	bank := banks - 1
+	if i := int(address-0x8000) / 0x2000; i < 3 {
+		bank = int(m.prgBanks[i])
+	}
+	return bank%banks*0x2000 + int(address%0x2000)
+}
+
+// page is the 1KB of CHR or CIRAM at bank i, and whether it can be written.
+// Values $E0 and up pick a CIRAM page, unless $E800 disabled that for the
+// pattern tables.
+func (m *MapperN163) page(i int) ([]byte, bool) {
+	bank := m.chrBanks[i]
+	ciram := bank >= 0xE0
+	if i < 8 && m.noCIRAM&(0x40<<uint(i/4)) != 0 {
+		ciram = false
+	}
+	if ciram {
+		return m.CIRAM[int(bank&1)*0x0400:], true
+	}
+	return m.CHR[int(bank)*0x0400%len(m.CHR):], m.CHRRAM
+}
+
+func (m *MapperN163) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		mem, _ := m.page(int(address / 0x0400))
+		return mem[address%0x0400]
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		if m.chipNVRAM {
+			return 0
+		}
+		return m.ReadRAM(address)
+	case address >= 0x5800:
+		return byte(m.counter >> 8)
+	case address >= 0x5000:
+		return byte(m.counter)
+	case address >= 0x4800:
+		val := m.ram[m.address&0x7F]
+		m.step()
+		return val
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal N163 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperN163) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		if mem, writable := m.page(int(address / 0x0400)); writable {
+			mem[address%0x0400] = val
+		}
+	case address >= 0x8000:
+		m.wRegister(address, val)
+	case address >= 0x6000:
+		// $F800 must hold $4x, and the bit of the 2KB page must be clear.
+		if !m.chipNVRAM && m.protect&0xF0 == 0x40 && m.protect&(1<<((address-0x6000)/0x0800)) == 0 {
+			m.WriteRAM(address, val)
+		}
+	case address >= 0x5800:
+		m.counter = m.counter&0x00FF | uint16(val)<<8
+		m.nes.CPU.SetIRQ(IRQMapper, false)
+	case address >= 0x5000:
+		m.counter = m.counter&0xFF00 | uint16(val)
+		m.nes.CPU.SetIRQ(IRQMapper, false)
+	case address >= 0x4800:
+		m.ram[m.address&0x7F] = val
+		m.step()
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal N163 write at address: $%04X", address)
+	}
+}
+
+// step moves the data port to the next byte, if auto-increment is on.
+func (m *MapperN163) step() {
+	if m.address&0x80 != 0 {
+		m.address = 0x80 | (m.address+1)&0x7F
+	}
+}
+
+func (m *MapperN163) wRegister(address uint16, val byte) {
+	switch i := int(address-0x8000) / 0x0800; {
+	case i < 12: // $8000-$DFFF: CHR and nametable banks
+		m.chrBanks[i] = val
+		if i >= 8 {
+			mem, writable := m.page(i)
+			m.MapNameTable(i-8, mem, writable)
+		}
+	case i == 12: // $E000
+		m.prgBanks[0] = val & 0x3F
+		m.soundOff = val&0x40 != 0
+	case i == 13: // $E800
+		m.prgBanks[1] = val & 0x3F
+		m.noCIRAM = val & 0xC0
+	case i == 14: // $F000
+		m.prgBanks[2] = val & 0x3F
+	case i == 15: // $F800
+		m.address = val
+		m.protect = val
+	}
+}
+
+func (m *MapperN163) Tick() {
+	if m.counter&0x8000 != 0 && m.counter&0x7FFF != 0x7FFF {
+		m.counter++
+		if m.counter&0x7FFF == 0x7FFF {
+			m.nes.CPU.SetIRQ(IRQMapper, true)
+		}
+	}
+	m.cycles++
+	if m.cycles == n163Cycles {
+		m.cycles = 0
+		if !m.soundOff {
+			m.clockChannel()
+		}
+	}
+}
+
+// channels is the number of enabled channels, from $7F bits 4-6. They are
+// the last ones, 8-n to 7.
+func (m *MapperN163) channels() int {
+	return int(m.ram[0x7F]>>4&7) + 1
+}
+
+// clockChannel updates the next channel, going down from channel 7.
+func (m *MapperN163) clockChannel() {
+	m.channel--
+	if m.channel < 8-m.channels() {
+		m.channel = 7
+	}
+	r := m.ram[0x40+8*m.channel:]
+	freq := int(r[0]) | int(r[2])<<8 | int(r[4]&3)<<16
+	phase := int(r[1]) | int(r[3])<<8 | int(r[5])<<16
+	length := 256 - int(r[4]&0xFC)
+	phase = (phase + freq) % (length << 16)
+	r[1], r[3], r[5] = byte(phase), byte(phase>>8), byte(phase>>16)
+	index := (phase>>16 + int(r[6])) & 0xFF
+	sample := int(m.ram[index/2] >> uint(index&1*4) & 0x0F)
+	m.out[m.channel] = (sample - 8) * int(r[7]&0x0F)
+}
+
+func (m *MapperN163) Run() {
+}
+
+// Output is the channel the chip is playing. It plays them one at a time,
+// each for 15 CPU cycles, so the more channels the quieter each one, and
+// with 6 to 8 channels the switching itself is an audible tone; the APU
+// filters do the averaging. A channel is -120 to 105, its sample less 8
+// times its volume, scaled to go from -pulseTable[15] to 7/8 of it: a wave
+// at full volume peaks at the level of an APU pulse at full volume.
+func (m *MapperN163) Output() float32 {
+	if m.noSound || m.muted[m.channel] {
+		return 0
+	}
+	return float32(m.out[m.channel]) * pulseTable[15] / 120
+}
+
+func (m *MapperN163) ChannelNames() []string {
+	return N163Channels
+}
+
+func (m *MapperN163) Mute(channel int, muted bool) {
+	m.muted[channel] = muted
+}
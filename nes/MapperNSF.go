@@ -0,0 +1,137 @@
+package nes
+
+// Mapper of the NSF player.
+// $5FF8-$5FFF select the 4KB banks at $8000-$FFFF, $6000-$7FFF is RAM.
+// The player has no ROM of its own: the vectors and a small idle loop are
+// answered by the mapper, and INIT/PLAY are entered by pushing the address of
+// the idle loop as return address and jumping to them, like a JSR would.
+
+const (
+	nsfIdle     = 0x5FF0 // JMP $5FF0
+	nsfBankBase = 0x5FF8
+)
+
+type MapperNSF struct {
+	*Cartridge
+	nes     *NES
+	nsf     *NSF
+	banks   [8]int
+	track   int
+	dots    int // PPU dots since the last PLAY
+	period  int // PPU dots between PLAY calls
+	pending bool
+	started bool
+}
+
+func NewMapperNSF(nes *NES, nsf *NSF) Mapper {
+	m := MapperNSF{Cartridge: nes.Cartridge, nes: nes, nsf: nsf}
+	m.period = int(nsf.Speed) * CPUFrequency * 3 / 1000000
+	if m.period == 0 {
+		m.period = 89342 // 60Hz
+	}
+	m.track = nsf.Start
+	return &m
+}
+
+func (m *MapperNSF) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[address]
+	case address >= 0xFFFA:
+		// All vectors point to the idle loop.
+		if address%2 == 0 {
+			return nsfIdle & 0xFF
+		}
+		return nsfIdle >> 8
+	case address >= 0x8000:
+		address -= 0x8000
+		return m.PRG[m.banks[address/0x1000]+int(address%0x1000)]
+	case address >= 0x6000:
+		return m.SRAM[address-0x6000]
+	case address >= nsfIdle && address < nsfIdle+3:
+		return [3]byte{0x4C, nsfIdle & 0xFF, nsfIdle >> 8}[address-nsfIdle]
+	}
+	return 0
+}
+
+func (m *MapperNSF) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.CHR[address] = val
+	case address >= 0x8000:
+	case address >= 0x6000:
+		m.SRAM[address-0x6000] = val
+	case address >= nsfBankBase:
+		m.setBank(int(address-nsfBankBase), val)
+	}
+}
+
+func (m *MapperNSF) setBank(slot int, val byte) {
+	m.banks[slot] = int(val) % (len(m.PRG) / 0x1000) * 0x1000
+}
+
+// call enters routine as if by JSR from the idle loop.
+func (m *MapperNSF) call(routine uint16) {
+	cpu := m.nes.CPU
+	cpu.push16(nsfIdle - 1) // RTS adds one
+	cpu.PC = routine
+}
+
+// SelectTrack resets the machine to the state the NSF spec promises and
+// calls INIT for track i, 0 based.
+func (m *MapperNSF) SelectTrack(i int) {
+	if m.nsf.Songs > 0 {
+		i = (i%m.nsf.Songs + m.nsf.Songs) % m.nsf.Songs
+	}
+	m.track = i
+	for j := range m.nes.RAM {
+		m.nes.RAM[j] = 0
+	}
+	for j := range m.SRAM {
+		m.SRAM[j] = 0
+	}
+	apu := m.nes.APU
+	for address := uint16(0x4000); address < 0x4014; address++ {
+		apu.WriteRegister(address, 0)
+	}
+	apu.WriteRegister(0x4015, 0x00)
+	apu.WriteRegister(0x4015, 0x0F)
+	apu.WriteRegister(0x4017, 0x40)
+	if m.nsf.Bankswitched() {
+		for slot, b := range m.nsf.Banks {
+			m.setBank(slot, b)
+		}
+	} else {
+		for slot := range m.banks {
+			m.setBank(slot, byte(slot))
+		}
+	}
+
+	cpu := m.nes.CPU
+	cpu.SP = 0xFD
+	cpu.SetFlags(0x24)
+	cpu.A = byte(i)
+	cpu.X = 0 // NTSC
+	cpu.Y = 0
+	m.call(m.nsf.Init)
+	m.dots = 0
+	m.pending = false
+	m.started = true
+}
+
+// Run counts PPU dots and calls PLAY at the rate the tune asks for, as soon
+// as the previous INIT or PLAY has returned.
+func (m *MapperNSF) Run() {
+	if !m.started {
+		m.SelectTrack(m.track)
+	}
+	m.dots++
+	if m.dots >= m.period {
+		m.dots -= m.period
+		m.pending = true
+	}
+	if m.pending && m.nes.CPU.PC == nsfIdle {
+		m.pending = false
+		m.call(m.nsf.Play)
+	}
+}
@@ -0,0 +1,119 @@
+package nes
+
+import "log"
+
+// Namco 108 family
+// The Namco 108 (DxROM, mapper 206) is the MMC3 bank switching alone, with
+// its registers at $8000-$9FFF and the bank modes stuck at 0: no IRQ, no
+// mirroring control and no PRG-RAM. Its boards wire the CHR lines in
+// different ways:
+// 76 (Namco 3446) doubles registers 2 to 5 into 2KB banks and ignores 0 and 1,
+// 88 (Namco 3433) puts the left pattern table in the first 64KB of CHR and the
+// right one in the second,
+// 154 (Namco 3453) is 88 with bit 6 of any write choosing one-screen
+// mirroring,
+// 95 (Namco 3425) takes the CIRAM page of each nametable half from bit 5 of
+// CHR registers 0 and 1.
+// Ref: http://wiki.nesdev.com/w/index.php/Namco_108
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_076
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_088
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_095
+// Ref: http://wiki.nesdev.com/w/index.php/INES_Mapper_154
+
+var namco108Boards = []struct {
+	mapper int
+	boards []string
+}{
+	{76, []string{"NAMCOT-3446"}},
+	{88, []string{"NAMCOT-3433", "NAMCOT-3443"}},
+	{95, []string{"NAMCOT-3425"}},
+	{154, []string{"NAMCOT-3453"}},
+	{206, []string{"DxROM", "NAMCOT-3401", "NAMCOT-3406", "NAMCOT-3407", "NAMCOT-3413", "NAMCOT-3414", "NAMCOT-3415", "NAMCOT-3416", "NAMCOT-3417", "NAMCOT-3451", "Namco 108"}},
+}
+
+type MapperNamco108 struct {
+	mmc3Banks
+}
+
+func init() {
+	for _, b := range namco108Boards {
+		RegisterMapper(b.mapper, AnySubmapper, func(nes *NES) (Mapper, error) {
+			return NewMapperNamco108(nes.Cartridge), nil
+		}, MapperInfo{Boards: b.boards})
+	}
+}
+
+func NewMapperNamco108(cartridge *Cartridge) Mapper {
+	m := MapperNamco108{newMMC3Banks(cartridge)}
+	m.updateOffsets()
+	return &m
+}
+
+func (m *MapperNamco108) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.readCHR(address)
+	case address >= 0x8000:
+		return m.readPRG(address)
+	case address >= 0x6000:
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal Namco 108 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperNamco108) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.writeCHR(address, val)
+	case address >= 0x8000:
+		if m.Mapper == 154 {
+			if val&0x40 == 0 {
+				m.SetMirror(MirrorSingle0)
+			} else {
+				m.SetMirror(MirrorSingle1)
+			}
+		}
+		if address >= 0xA000 {
+			return
+		}
+		if address%2 == 0 {
+			m.register = val & 7
+		} else {
+			m.registers[m.register] = val & 0x3F
+			m.updateOffsets()
+		}
+	case address >= 0x6000:
+		m.WriteRAM(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal Namco 108 write at address: $%04X", address)
+	}
+}
+
+func (m *MapperNamco108) updateOffsets() {
+	m.mmc3Banks.updateOffsets()
+	switch m.Mapper {
+	case 76:
+		for i := 0; i < 4; i++ {
+			bank := int(m.registers[2+i]) * 2
+			m.chrOffsets[i*2] = m.chrBankOffset(bank)
+			m.chrOffsets[i*2+1] = m.chrBankOffset(bank + 1)
+		}
+	case 88, 154:
+		for i := 4; i < 8; i++ {
+			m.chrOffsets[i] = m.chrBankOffset(int(m.registers[i-2]) | 0x40)
+		}
+	case 95:
+		for i := 0; i < 4; i++ {
+			page := int(m.registers[i/2]>>5) & 1
+			m.MapNameTable(i, m.CIRAM[page*0x0400:], true)
+		}
+	}
+}
+
+func (m *MapperNamco108) Run() {
+}
@@ -0,0 +1,117 @@
+package nes
+
+import "log"
+
+// UNROM 512
+// UxROM with up to 512KB of PRG, 32KB of CHR-RAM in four 8KB banks and a
+// one-screen mirroring bit, all in one register: PPPPP bank at $8000, CC
+// CHR bank, M nametable. The fixed bank at $C000 is the last one.
+// Boards with a battery in the header are self-flashable: PRG is an
+// SST39SF040 written through $8000-$BFFF, the register moves to
+// $C000-$FFFF and there are no bus conflicts. What the game flashes is kept
+// in its save file.
+// Submapper 1 is never flashable. Four-screen headers are taken as the
+// one-screen mirroring bit, which nearly every game uses.
+// Ref: http://wiki.nesdev.com/w/index.php/UNROM_512
+
+type MapperUNROM512 struct {
+	*Cartridge
+	prgBank   int
+	chrBank   int
+	oneScreen bool
+	flashable bool
+	conflicts bool
+	flash     sstFlash
+}
+
+func init() {
+	RegisterMapper(30, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperUNROM512(nes.Cartridge), nil
+	}, MapperInfo{Boards: []string{"UNROM 512"}})
+}
+
+func NewMapperUNROM512(cartridge *Cartridge) Mapper {
+	m := MapperUNROM512{Cartridge: cartridge, flash: sstFlash{data: cartridge.PRG}}
+	m.flashable = cartridge.Battery != 0 && cartridge.Submapper != 1
+	m.conflicts = cartridge.BusConflicts(!m.flashable)
+	if m.CHRRAM && len(m.CHR) < 0x8000 {
+		m.CHR = make([]byte, 0x8000)
+	}
+	if m.Mirror == MirrorFour {
+		m.oneScreen = true
+		m.SetMirror(MirrorSingle0)
+	}
+	return &m
+}
+
+func (m *MapperUNROM512) prgOffset(address uint16) int {
+	bank := m.prgBank
+	if address >= 0xC000 {
+		bank = len(m.PRG)/0x4000 - 1
+	}
+	return (bank*0x4000 + int(address%0x4000)) % len(m.PRG)
+}
+
+func (m *MapperUNROM512) chrOffset(address uint16) int {
+	return (m.chrBank*0x2000 + int(address)) % len(m.CHR)
+}
+
+func (m *MapperUNROM512) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		return m.flash.read(m.prgOffset(address))
+	case address >= 0x6000:
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal UNROM 512 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperUNROM512) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0x8000:
+		if m.flashable && address < 0xC000 {
+			m.flash.write(m.prgOffset(address), val)
+			return
+		}
+		if m.conflicts {
+			val &= m.Read(address)
+		}
+		m.prgBank = int(val & 0x1F)
+		m.chrBank = int(val >> 5 & 3)
+		if m.oneScreen {
+			if val&0x80 == 0 {
+				m.SetMirror(MirrorSingle0)
+			} else {
+				m.SetMirror(MirrorSingle1)
+			}
+		}
+	case address >= 0x6000:
+		m.WriteRAM(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal UNROM 512 write at address: $%04X", address)
+	}
+}
+
+func (m *MapperUNROM512) Run() {
+}
+
+// LoadSave puts the flashed PRG back, on boards that can flash it.
+func (m *MapperUNROM512) LoadSave(data []byte) error {
+	if !m.flashable {
+		return nil
+	}
+	return m.flash.load(data)
+}
+
+func (m *MapperUNROM512) Save() []byte {
+	return m.flash.save()
+}
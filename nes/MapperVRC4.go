@@ -0,0 +1,205 @@
+package nes
+
+import "log"
+
+// Konami VRC2 and VRC4
+// Mappers 21, 22, 23 and 25 are the same chips with their A0 and A1 pins on
+// different CPU address lines, which the NES 2.0 submapper tells apart.
+// Without a submapper the lines of all the variants of the mapper are used
+// together, which works for nearly every game.
+// Ref: http://wiki.nesdev.com/w/index.php/VRC2_and_VRC4
+
+// vrcPins are the CPU address lines on the VRC's A0 and A1.
+type vrcPins struct {
+	a0, a1 uint16
+}
+
+var vrc4Variants = []struct {
+	mapper, submapper int
+	board             string
+	pins              vrcPins
+}{
+	{21, AnySubmapper, "VRC4a/VRC4c", vrcPins{0x42, 0x84}},
+	{21, 1, "VRC4a", vrcPins{0x02, 0x04}},
+	{21, 2, "VRC4c", vrcPins{0x40, 0x80}},
+	{22, AnySubmapper, "VRC2a", vrcPins{0x02, 0x01}},
+	{23, AnySubmapper, "VRC2b/VRC4e/VRC4f", vrcPins{0x05, 0x0A}},
+	{23, 1, "VRC4f", vrcPins{0x01, 0x02}},
+	{23, 2, "VRC4e", vrcPins{0x04, 0x08}},
+	{23, 3, "VRC2b", vrcPins{0x01, 0x02}},
+	{25, AnySubmapper, "VRC2c/VRC4b/VRC4d", vrcPins{0x0A, 0x05}},
+	{25, 1, "VRC4b", vrcPins{0x02, 0x01}},
+	{25, 2, "VRC4d", vrcPins{0x08, 0x04}},
+	{25, 3, "VRC2c", vrcPins{0x02, 0x01}},
+}
+
+type MapperVRC4 struct {
+	*Cartridge
+	nes      *NES
+	pins     vrcPins
+	vrc2     bool
+	chrShift uint // VRC2a leaves out the low bit of the CHR banks
+	prgBanks [2]byte
+	prgSwap  bool
+	chrBanks [8]int
+	latch    byte // VRC2 1-bit latch at $6000, for boards without PRG-RAM
+	irq      vrcIRQ
+}
+
+func init() {
+	for _, v := range vrc4Variants {
+		v := v
+		RegisterMapper(v.mapper, v.submapper, func(nes *NES) (Mapper, error) {
+			return NewMapperVRC4(nes, nes.Cartridge, v.pins), nil
+		}, MapperInfo{Boards: []string{v.board}})
+	}
+}
+
+func NewMapperVRC4(nes *NES, cartridge *Cartridge, pins vrcPins) Mapper {
+	m := MapperVRC4{Cartridge: cartridge, nes: nes, pins: pins}
+	m.vrc2 = cartridge.Mapper == 22 || cartridge.Submapper == 3
+	if cartridge.Mapper == 22 {
+		m.chrShift = 1
+	}
+	return &m
+}
+
+func (m *MapperVRC4) prgOffset(address uint16) int {
+	banks := len(m.PRG) / 0x2000
+	var bank int
+	switch address / 0x2000 {
+	case 4: // $8000
+		bank = int(m.prgBanks[0])
+		if m.prgSwap {
+			bank = banks - 2
+		}
+	case 5: // $A000
+		bank = int(m.prgBanks[1])
+	case 6: // $C000
+		bank = banks - 2
+		if m.prgSwap {
+			bank = int(m.prgBanks[0])
+		}
+	case 7: // $E000
+		bank = banks - 1
+	}
+	return bank%banks*0x2000 + int(address%0x2000)
+}
+
+func (m *MapperVRC4) chrOffset(address uint16) int {
+	bank := m.chrBanks[address/0x0400] >> m.chrShift
+	return (bank*0x0400 + int(address%0x0400)) % len(m.CHR)
+}
+
+func (m *MapperVRC4) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		if len(m.SRAM) == 0 && m.vrc2 && address < 0x7000 {
+			return m.latch
+		}
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal VRC4 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperVRC4) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0x8000:
+		register := address & 0xF000
+		if address&m.pins.a0 != 0 {
+			register |= 1
+		}
+		if address&m.pins.a1 != 0 {
+			register |= 2
+		}
+		m.wRegister(register, val)
+	case address >= 0x6000:
+		if len(m.SRAM) == 0 && m.vrc2 && address < 0x7000 {
+			m.latch = val & 1
+		}
+		m.WriteRAM(address, val)
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal VRC4 write at address: $%04X", address)
+	}
+}
+
+func (m *MapperVRC4) wRegister(register uint16, val byte) {
+	switch {
+	case register < 0x9000:
+		m.prgBanks[0] = val & 0x1F
+	case register < 0xA000:
+		m.wControl(register, val)
+	case register < 0xB000:
+		m.prgBanks[1] = val & 0x1F
+	case register < 0xF000:
+		// Two registers for each 1KB bank: low and high bits.
+		i := int(register-0xB000)>>12*2 + int(register&2)>>1
+		if register&1 == 0 {
+			m.chrBanks[i] = m.chrBanks[i]&^0x0F | int(val&0x0F)
+		} else if m.vrc2 {
+			m.chrBanks[i] = m.chrBanks[i]&0x0F | int(val&0x0F)<<4
+		} else {
+			m.chrBanks[i] = m.chrBanks[i]&0x0F | int(val&0x1F)<<4
+		}
+	case !m.vrc2:
+		m.wIRQ(register, val)
+	}
+}
+
+// $9000-$9003: mirroring, and on the VRC4 the PRG swap mode at $9002.
+func (m *MapperVRC4) wControl(register uint16, val byte) {
+	if m.vrc2 {
+		val &= 1
+	} else if register&3 == 2 {
+		m.prgSwap = val&2 != 0
+		return
+	} else if register&3 == 3 {
+		return
+	}
+	switch val & 3 {
+	case 0:
+		m.SetMirror(MirrorVertical)
+	case 1:
+		m.SetMirror(MirrorHorizontal)
+	case 2:
+		m.SetMirror(MirrorSingle0)
+	case 3:
+		m.SetMirror(MirrorSingle1)
+	}
+}
+
+// $F000-$F003: IRQ latch low and high nibble, control, acknowledge.
+func (m *MapperVRC4) wIRQ(register uint16, val byte) {
+	switch register & 3 {
+	case 0:
+		m.irq.wLatch(m.irq.latch&0xF0 | val&0x0F)
+	case 1:
+		m.irq.wLatch(m.irq.latch&0x0F | val<<4)
+	case 2:
+		m.irq.wControl(val)
+	case 3:
+		m.irq.ack()
+	}
+	m.nes.CPU.SetIRQ(IRQMapper, m.irq.pending)
+}
+
+func (m *MapperVRC4) Tick() {
+	if !m.vrc2 {
+		m.irq.tick()
+		m.nes.CPU.SetIRQ(IRQMapper, m.irq.pending)
+	}
+}
+
+func (m *MapperVRC4) Run() {
+}
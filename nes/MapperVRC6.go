@@ -0,0 +1,284 @@
+package nes
+
+import "log"
+
+// Konami VRC6
+// Mapper 26 (VRC6b) has A0 and A1 swapped against mapper 24 (VRC6a). Only
+// the PPU banking mode all the games use is emulated: eight 1KB CHR banks
+// and CIRAM nametables.
+// Ref: http://wiki.nesdev.com/w/index.php/VRC6
+// Ref: http://wiki.nesdev.com/w/index.php/VRC6_audio
+
+// VRC6Channels names the VRC6 audio channels, for APU.Mute.
+var VRC6Channels = []string{"vrc6-pulse1", "vrc6-pulse2", "vrc6-saw"}
+
+type vrc6Pulse struct {
+	volume  byte
+	duty    byte
+	mode    bool // ignore the duty, always on
+	enabled bool
+	period  uint16
+	timer   uint16
+	step    byte
+}
+
+func (p *vrc6Pulse) write(register uint16, val byte) {
+	switch register {
+	case 0:
+		p.mode = val&0x80 != 0
+		p.duty = val >> 4 & 7
+		p.volume = val & 0x0F
+	case 1:
+		p.period = p.period&0x0F00 | uint16(val)
+	case 2:
+		p.period = p.period&0x00FF | uint16(val&0x0F)<<8
+		p.enabled = val&0x80 != 0
+		if !p.enabled {
+			p.step = 0
+		}
+	}
+}
+
+func (p *vrc6Pulse) tick(shift uint) {
+	if !p.enabled {
+		return
+	}
+	if p.timer == 0 {
+		p.timer = p.period >> shift
+		p.step = (p.step + 1) % 16
+	} else {
+		p.timer--
+	}
+}
+
+func (p *vrc6Pulse) output() byte {
+	if !p.enabled || !p.mode && p.step > p.duty {
+		return 0
+	}
+	return p.volume
+}
+
+type vrc6Saw struct {
+	rate    byte
+	enabled bool
+	period  uint16
+	timer   uint16
+	step    byte
+	acc     byte
+}
+
+func (s *vrc6Saw) write(register uint16, val byte) {
+	switch register {
+	case 0:
+		s.rate = val & 0x3F
+	case 1:
+		s.period = s.period&0x0F00 | uint16(val)
+	case 2:
+		s.period = s.period&0x00FF | uint16(val&0x0F)<<8
+		s.enabled = val&0x80 != 0
+		if !s.enabled {
+			s.step = 0
+			s.acc = 0
+		}
+	}
+}
+
+// tick adds the rate to the accumulator every second clock, and resets it
+// on the fourteenth.
+func (s *vrc6Saw) tick(shift uint) {
+	if !s.enabled {
+		return
+	}
+	if s.timer > 0 {
+		s.timer--
+		return
+	}
+	s.timer = s.period >> shift
+	s.step++
+	if s.step == 14 {
+		s.step = 0
+		s.acc = 0
+	} else if s.step%2 == 0 {
+		s.acc += s.rate
+	}
+}
+
+func (s *vrc6Saw) output() byte {
+	return s.acc >> 3
+}
+
+type MapperVRC6 struct {
+	*Cartridge
+	nes       *NES
+	swap      bool // VRC6b
+	prg16     byte
+	prg8      byte
+	chrBanks  [8]int
+	ramEnable bool
+	irq       vrcIRQ
+	pulse1    vrc6Pulse
+	pulse2    vrc6Pulse
+	saw       vrc6Saw
+	halt      bool
+	shift     uint // frequency scaling of $9003
+	muted     [3]bool
+}
+
+func init() {
+	RegisterMapper(24, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperVRC6(nes, nes.Cartridge, false), nil
+	}, MapperInfo{Boards: []string{"VRC6a"}})
+	RegisterMapper(26, AnySubmapper, func(nes *NES) (Mapper, error) {
+		return NewMapperVRC6(nes, nes.Cartridge, true), nil
+	}, MapperInfo{Boards: []string{"VRC6b"}})
+}
+
+func NewMapperVRC6(nes *NES, cartridge *Cartridge, swap bool) Mapper {
+	return &MapperVRC6{Cartridge: cartridge, nes: nes, swap: swap}
+}
+
+func (m *MapperVRC6) prgOffset(address uint16) int {
+	var offset int
+	switch {
+	case address < 0xC000:
+		offset = int(m.prg16)*0x4000 + int(address-0x8000)
+	case address < 0xE000:
+		offset = int(m.prg8)*0x2000 + int(address-0xC000)
+	default:
+		offset = len(m.PRG) - 0x2000 + int(address-0xE000)
+	}
+	return offset % len(m.PRG)
+}
+
+func (m *MapperVRC6) chrOffset(address uint16) int {
+	return (m.chrBanks[address/0x0400]*0x0400 + int(address%0x0400)) % len(m.CHR)
+}
+
+func (m *MapperVRC6) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		if !m.ramEnable {
+			return 0
+		}
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal VRC6 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperVRC6) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0x8000:
+		register := address & 0xF003
+		if m.swap {
+			register = register&0xF000 | register&1<<1 | register&2>>1
+		}
+		m.wRegister(register, val)
+	case address >= 0x6000:
+		if m.ramEnable {
+			m.WriteRAM(address, val)
+		}
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal VRC6 write at address: $%04X", address)
+	}
+}
+
+func (m *MapperVRC6) wRegister(register uint16, val byte) {
+	switch register & 0xF000 {
+	case 0x8000:
+		m.prg16 = val & 0x0F
+	case 0x9000:
+		if register == 0x9003 {
+			m.halt = val&1 != 0
+			switch {
+			case val&4 != 0:
+				m.shift = 8
+			case val&2 != 0:
+				m.shift = 4
+			default:
+				m.shift = 0
+			}
+		} else {
+			m.pulse1.write(register&3, val)
+		}
+	case 0xA000:
+		m.pulse2.write(register&3, val)
+	case 0xB000:
+		if register == 0xB003 {
+			m.wControl(val)
+		} else {
+			m.saw.write(register&3, val)
+		}
+	case 0xC000:
+		m.prg8 = val & 0x1F
+	case 0xD000, 0xE000:
+		m.chrBanks[int(register-0xD000)>>12*4+int(register&3)] = int(val)
+	case 0xF000:
+		switch register & 3 {
+		case 0:
+			m.irq.wLatch(val)
+		case 1:
+			m.irq.wControl(val)
+		case 2:
+			m.irq.ack()
+		}
+		m.nes.CPU.SetIRQ(IRQMapper, m.irq.pending)
+	}
+}
+
+// $B003: PRG-RAM enable and mirroring.
+func (m *MapperVRC6) wControl(val byte) {
+	m.ramEnable = val&0x80 != 0
+	switch val >> 2 & 3 {
+	case 0:
+		m.SetMirror(MirrorVertical)
+	case 1:
+		m.SetMirror(MirrorHorizontal)
+	case 2:
+		m.SetMirror(MirrorSingle0)
+	case 3:
+		m.SetMirror(MirrorSingle1)
+	}
+}
+
+func (m *MapperVRC6) Tick() {
+	m.irq.tick()
+	m.nes.CPU.SetIRQ(IRQMapper, m.irq.pending)
+	if !m.halt {
+		m.pulse1.tick(m.shift)
+		m.pulse2.tick(m.shift)
+		m.saw.tick(m.shift)
+	}
+}
+
+func (m *MapperVRC6) Run() {
+}
+
+func (m *MapperVRC6) Output() float32 {
+	var out int
+	for i, v := range [3]byte{m.pulse1.output(), m.pulse2.output(), m.saw.output()} {
+		if !m.muted[i] {
+			out += int(v)
+		}
+	}
+	// A VRC6 pulse at full volume is about as loud as an APU pulse.
+	return float32(out) * pulseTable[15] / 15
+}
+
+func (m *MapperVRC6) ChannelNames() []string {
+	return VRC6Channels
+}
+
+func (m *MapperVRC6) Mute(channel int, muted bool) {
+	m.muted[channel] = muted
+}
@@ -0,0 +1,179 @@
+package nes
+
+import "log"
+
+// Konami VRC7
+// Boards put the VRC7's A0 on CPU A4 (VRC7a, Lagrange Point) or A3 (VRC7b),
+// told apart by the NES 2.0 submapper. Without one both lines are used.
+// Ref: http://wiki.nesdev.com/w/index.php/VRC7
+
+var vrc7Variants = []struct {
+	submapper int
+	board     string
+	a0        uint16
+}{
+	{AnySubmapper, "VRC7", 0x18},
+	{1, "VRC7b", 0x08},
+	{2, "VRC7a", 0x10},
+}
+
+type MapperVRC7 struct {
+	*Cartridge
+	nes       *NES
+	a0        uint16
+	prgBanks  [3]byte
+	chrBanks  [8]int
+	ramEnable bool
+	silence   bool // $E000 bit 6 holds the audio in reset
+	irq       vrcIRQ
+	opll      OPLL
+}
+
+func init() {
+	for _, v := range vrc7Variants {
+		v := v
+		RegisterMapper(85, v.submapper, func(nes *NES) (Mapper, error) {
+			return NewMapperVRC7(nes, nes.Cartridge, v.a0), nil
+		}, MapperInfo{Boards: []string{v.board}})
+	}
+}
+
+func NewMapperVRC7(nes *NES, cartridge *Cartridge, a0 uint16) Mapper {
+	m := MapperVRC7{Cartridge: cartridge, nes: nes, a0: a0}
+	m.opll.Reset()
+	return &m
+}
+
+func (m *MapperVRC7) prgOffset(address uint16) int {
+	banks := len(m.PRG) / 0x2000
+	bank := banks - 1
+	if i := int(address-0x8000) / 0x2000; i < 3 {
+		bank = int(m.prgBanks[i])
+	}
+	return bank%banks*0x2000 + int(address%0x2000)
+}
+
+func (m *MapperVRC7) chrOffset(address uint16) int {
+	return (m.chrBanks[address/0x0400]*0x0400 + int(address%0x0400)) % len(m.CHR)
+}
+
+func (m *MapperVRC7) Read(address uint16) byte {
+	switch {
+	case address < 0x2000:
+		return m.CHR[m.chrOffset(address)]
+	case address >= 0x8000:
+		return m.PRG[m.prgOffset(address)]
+	case address >= 0x6000:
+		if !m.ramEnable {
+			return 0
+		}
+		return m.ReadRAM(address)
+	case address >= 0x4020:
+		return 0 // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal VRC7 read at address: $%04X", address)
+	}
+	return 0
+}
+
+func (m *MapperVRC7) Write(address uint16, val byte) {
+	switch {
+	case address < 0x2000:
+		m.WriteCHR(m.chrOffset(address), val)
+	case address >= 0x8000:
+		m.wRegister(address, val)
+	case address >= 0x6000:
+		if m.ramEnable {
+			m.WriteRAM(address, val)
+		}
+	case address >= 0x4020: // nothing on the expansion bus
+	default:
+		log.Fatalf("Illegal VRC7 write at address: $%04X", address)
+	}
+}
+
+func (m *MapperVRC7) wRegister(address uint16, val byte) {
+	high := address&m.a0 != 0
+	switch address & 0xF000 {
+	case 0x8000:
+		if high {
+			m.prgBanks[1] = val & 0x3F
+		} else {
+			m.prgBanks[0] = val & 0x3F
+		}
+	case 0x9000:
+		switch {
+		case address&0x30 == 0x10:
+			m.opll.wAddress(val)
+		case address&0x30 == 0x30:
+			m.opll.wData(val)
+		case !high:
+			m.prgBanks[2] = val & 0x3F
+		}
+	case 0xA000, 0xB000, 0xC000, 0xD000:
+		i := int(address-0xA000) >> 12 * 2
+		if high {
+			i++
+		}
+		m.chrBanks[i] = int(val)
+	case 0xE000:
+		if high {
+			m.irq.wLatch(val)
+		} else {
+			m.wControl(val)
+		}
+	case 0xF000:
+		if high {
+			m.irq.ack()
+		} else {
+			m.irq.wControl(val)
+		}
+		m.nes.CPU.SetIRQ(IRQMapper, m.irq.pending)
+	}
+}
+
+// $E000: mirroring, audio reset and PRG-RAM enable.
+func (m *MapperVRC7) wControl(val byte) {
+	switch val & 3 {
+	case 0:
+		m.SetMirror(MirrorVertical)
+	case 1:
+		m.SetMirror(MirrorHorizontal)
+	case 2:
+		m.SetMirror(MirrorSingle0)
+	case 3:
+		m.SetMirror(MirrorSingle1)
+	}
+	m.silence = val&0x40 != 0
+	if m.silence {
+		m.opll.Reset()
+	}
+	m.ramEnable = val&0x80 != 0
+}
+
+func (m *MapperVRC7) Tick() {
+	m.irq.tick()
+	m.nes.CPU.SetIRQ(IRQMapper, m.irq.pending)
+	if !m.silence {
+		m.opll.Tick()
+	}
+}
+
+func (m *MapperVRC7) Run() {
+}
+
+// Output mixes the FM channels. The OPLL gives -1 to 1 for each, so a
+// channel at full volume swings between -pulseTable[15] and pulseTable[15],
+// peaking at the level of an APU pulse at full volume, and the six together
+// up to six times that.
+func (m *MapperVRC7) Output() float32 {
+	return m.opll.Output() * pulseTable[15]
+}
+
+func (m *MapperVRC7) ChannelNames() []string {
+	return OPLLChannels
+}
+
+func (m *MapperVRC7) Mute(channel int, muted bool) {
+	m.opll.muted[channel] = muted
+}
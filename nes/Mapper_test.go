@@ -0,0 +1,844 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookupMapper(t *testing.T) {
+	ctor := func(nes *NES) (Mapper, error) { return nil, nil }
+	RegisterMapper(4095, AnySubmapper, ctor, MapperInfo{Boards: []string{"ANY"}})
+	RegisterMapper(4095, 3, ctor, MapperInfo{Boards: []string{"THREE"}})
+	defer delete(mappers, [2]int{4095, AnySubmapper})
+	defer delete(mappers, [2]int{4095, 3})
+
+	if info, ok := LookupMapper(4095, 3); !ok || info.Boards[0] != "THREE" {
+		t.Errorf("LookupMapper(4095, 3) = %v, %v", info.Boards, ok)
+	}
+	if info, ok := LookupMapper(4095, 1); !ok || info.Boards[0] != "ANY" {
+		t.Errorf("LookupMapper(4095, 1) = %v, %v", info.Boards, ok)
+	}
+	if _, ok := LookupMapper(4094, 0); ok {
+		t.Error("LookupMapper(4094, 0) found a mapper")
+	}
+}
+
+func TestLoadDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kuso-nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db.xml")
+	xml := `<database version="1.0">
+	<game>
+		<cartridge system="NES-NTSC" crc="1234ABCD">
+			<board type="NES-SNROM" mapper="1">
+				<prg size="256k"/>
+				<vram size="8k"/>
+				<wram size="8k" battery="1"/>
+				<chip type="MMC1B2"/>
+			</board>
+		</cartridge>
+	</game>
+	<game>
+		<cartridge system="NES-NTSC" crc="1234ABCE">
+			<board type="NES-UNROM">
+				<prg size="128k"/>
+			</board>
+		</cartridge>
+	</game>
+</database>`
+	if err := ioutil.WriteFile(path, []byte(xml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadDatabase(path); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(database, 0x1234ABCD)
+	defer delete(database, 0x1234ABCE)
+
+	game, ok := LookupGame(0x1234ABCD)
+	if !ok {
+		t.Fatal("game not found")
+	}
+	if game.Board != "NES-SNROM" || game.Mapper != 1 || game.PRGROM != 256*1024 ||
+		game.CHRRAM != 8192 || game.PRGNVRAM != 8192 || game.PRGRAM != 0 {
+		t.Errorf("unexpected game info: %+v", game)
+	}
+	if game, ok := LookupGame(0x1234ABCE); !ok {
+		t.Error("game without mapper attribute not found")
+	} else if game.Mapper != -1 {
+		t.Errorf("board without mapper attribute has mapper %d, want -1", game.Mapper)
+	}
+}
+
+func TestMapper0(t *testing.T) {
+	prg := make([]byte, 0x4000)
+	prg[0x0123] = 0x42
+	chr := make([]byte, 0x2000)
+	c := NewCartridge(prg, chr, 0, MirrorVertical, 0)
+	c.SetRAM(0x0800, 0) // Family BASIC
+	m := NewMapper0(c)
+
+	if a, b := m.Read(0x8123), m.Read(0xC123); a != 0x42 || b != 0x42 {
+		t.Errorf("NROM-128 reads $%02X at $8123 and $%02X at $C123", a, b)
+	}
+	m.Write(0x8000, 1)
+	if m.Read(0x8123) != 0x42 {
+		t.Error("NROM switched banks")
+	}
+	m.Write(0x0010, 0xFF)
+	if m.Read(0x0010) != 0 {
+		t.Error("CHR-ROM was written")
+	}
+	m.Write(0x6001, 0x55)
+	if m.Read(0x7801) != 0x55 {
+		t.Error("2KB PRG-RAM is not mirrored")
+	}
+}
+
+func TestBusConflicts(t *testing.T) {
+	prg := make([]byte, 0x8000)
+	prg[0x0000] = 0x01
+	chr := make([]byte, 0x8000)
+	chr[0x2000] = 0x11 // bank 1
+	chr[0x6000] = 0x33 // bank 3
+
+	c := NewCartridge(prg, chr, 3, MirrorVertical, 0)
+	m := NewMapper3(c)
+	m.Write(0x8000, 3)
+	if got := m.Read(0x0000); got != 0x11 {
+		t.Errorf("CNROM with bus conflicts reads CHR $%02X, want $11", got)
+	}
+
+	c.Submapper = 1
+	m = NewMapper3(c)
+	m.Write(0x8000, 3)
+	if got := m.Read(0x0000); got != 0x33 {
+		t.Errorf("CNROM without bus conflicts reads CHR $%02X, want $33", got)
+	}
+}
+
+func TestMMC6RAM(t *testing.T) {
+	c := NewCartridge(make([]byte, 0x8000), make([]byte, 0x2000), 4, MirrorVertical, 1)
+	c.Submapper = 1
+	m := NewMapper4(nil, c)
+
+	m.Write(0x8000, 0x20) // RAM on
+	m.Write(0xA001, 0x30) // $7000-$71FF read/write
+	m.Write(0x7001, 0x11)
+	m.Write(0x7201, 0x22)
+	if got := m.Read(0x7401); got != 0x11 {
+		t.Errorf("MMC6 RAM mirror reads $%02X, want $11", got)
+	}
+	m.Write(0xA001, 0xA0) // both halves read only
+	m.Write(0x7001, 0x33)
+	if got, hi := m.Read(0x7001), m.Read(0x7201); got != 0x11 || hi != 0 {
+		t.Errorf("write-protected MMC6 RAM reads $%02X $%02X, want $11 $00", got, hi)
+	}
+}
+
+func TestMapper1SUROM(t *testing.T) {
+	prg := make([]byte, 0x80000)
+	for i := 0; i < len(prg); i += 0x4000 {
+		prg[i] = byte(i / 0x4000)
+	}
+	c := NewCartridge(prg, make([]byte, 0x2000), 1, MirrorVertical, 0)
+	c.CHRRAM = true
+	m := NewMapper1(c)
+	serial := func(address uint16, val byte) {
+		for i := 0; i < 5; i++ {
+			m.Write(address, val>>uint(i)&1)
+			m.Tick()
+		}
+	}
+
+	if got := m.Read(0xC000); got != 15 {
+		t.Errorf("fixed bank at power on is %d, want 15", got)
+	}
+	serial(0xA000, 0x10)
+	if got := m.Read(0xC000); got != 31 {
+		t.Errorf("fixed bank in the second 256KB is %d, want 31", got)
+	}
+
+	// A read-modify-write writes twice in a row: only the first counts.
+	m.Write(0x8000, 0x80)
+	m.Write(0x8000, 0x01)
+	m.Tick()
+	serial(0xE000, 0x02)
+	if got := m.Read(0x8000); got != 18 {
+		t.Errorf("switchable bank is %d, want 18", got)
+	}
+}
+
+// newTestMMC5 puts an MMC5 in a console with the CPU and PPU it watches.
+func newTestMMC5(prg, chr []byte) (*NES, Mapper) {
+	nes := &NES{Cartridge: NewCartridge(prg, chr, 5, MirrorVertical, 0)}
+	nes.Mapper = NewMapper5(nes, nes.Cartridge)
+	nes.CPU = NewCPU(NewCPUMemory(nes))
+	nes.PPU = NewPPU(nes)
+	return nes, nes.Mapper
+}
+
+func TestMMC5Banking(t *testing.T) {
+	prg := make([]byte, 0x20000)
+	for i := 0; i < len(prg); i += 0x2000 {
+		prg[i] = byte(i / 0x2000)
+	}
+	chr := make([]byte, 0x20000)
+	for i := 0; i < len(chr); i += 0x0400 {
+		chr[i] = byte(i / 0x0400)
+	}
+	_, m := newTestMMC5(prg, chr)
+	tests := []struct {
+		name    string
+		writes  [][2]uint16
+		address uint16
+		want    byte
+	}{
+		{"PRG mode 3, $8000", [][2]uint16{{0x5114, 0x81}}, 0x8000, 1},
+		{"PRG mode 3, $E000", nil, 0xE000, 15},
+		{"PRG mode 1, $A000", [][2]uint16{{0x5100, 1}, {0x5115, 0x84}}, 0xA000, 5},
+		{"PRG mode 1, $C000", [][2]uint16{{0x5117, 0x87}}, 0xC000, 6},
+		{"PRG mode 0, $8000", [][2]uint16{{0x5100, 0}, {0x5117, 0x8B}}, 0x8000, 8},
+		{"PRG mode 0, $E000", nil, 0xE000, 11},
+		{"CHR mode 3, $1C00", [][2]uint16{{0x5127, 9}}, 0x1C00, 9},
+		{"CHR mode 2, $0C00", [][2]uint16{{0x5101, 2}, {0x5123, 3}}, 0x0C00, 7},
+		{"CHR mode 1, $0400", [][2]uint16{{0x5101, 1}, {0x5123, 2}}, 0x0400, 9},
+		{"CHR mode 0, $1C00", [][2]uint16{{0x5101, 0}, {0x5127, 1}}, 0x1C00, 15},
+	}
+	for _, tt := range tests {
+		for _, w := range tt.writes {
+			m.Write(w[0], byte(w[1]))
+		}
+		if got := m.Read(tt.address); got != tt.want {
+			t.Errorf("%s: read bank %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMMC5Multiplier(t *testing.T) {
+	_, m := newTestMMC5(make([]byte, 0x8000), make([]byte, 0x2000))
+	m.Write(0x5205, 200)
+	m.Write(0x5206, 100)
+	if lo, hi := m.Read(0x5205), m.Read(0x5206); lo != 0x20 || hi != 0x4E {
+		t.Errorf("200*100 reads $%02X%02X, want $4E20", hi, lo)
+	}
+}
+
+func TestMMC5ExAttributes(t *testing.T) {
+	chr := make([]byte, 0x80000)
+	chr[69*0x1000+0x10] = 0xAB // bank 5 with the upper bits 1
+	nes, m := newTestMMC5(make([]byte, 0x8000), chr)
+	m.Write(0x5104, 1) // ExRAM as extended attributes
+	m.Write(0x5130, 1)
+	m.Write(0x5C05, 0xC5)
+	if got := m.Read(0x5C05); got != 0 {
+		t.Errorf("ExRAM mode 1 reads $%02X, want 0", got)
+	}
+	for i := 0; i < 3; i++ {
+		m.PPUAddress(0x2000)
+	}
+	m.Write(0x5C05, 0xC5) // only taken while rendering
+	nes.PPU.fShowBackground = 1
+	nes.PPU.ScanLine, nes.PPU.Cycle = 0, 1
+	m.ReadNameTable(0x2005)
+	if got := m.ReadNameTable(0x23C1); got != 0xFF {
+		t.Errorf("attribute reads $%02X, want palette 3 from ExRAM", got)
+	}
+	if got := m.Read(0x0010); got != 0xAB {
+		t.Errorf("pattern reads $%02X, want $AB from 4KB bank 69", got)
+	}
+}
+
+func TestMMC5ScanlineIRQ(t *testing.T) {
+	nes, m := newTestMMC5(make([]byte, 0x8000), make([]byte, 0x2000))
+	m.Write(0x5203, 2)
+	m.Write(0x5204, 0x80)
+	scanline := func(address uint16) {
+		for i := 0; i < 3; i++ {
+			m.PPUAddress(address)
+		}
+	}
+	scanline(0x2000)
+	scanline(0x2040)
+	if nes.CPU.IRQ(IRQMapper) {
+		t.Error("IRQ on scanline 1, want it on 2")
+	}
+	scanline(0x2080)
+	if !nes.CPU.IRQ(IRQMapper) {
+		t.Error("no IRQ on scanline 2")
+	}
+	if got := m.Read(0x5204); got != 0xC0 {
+		t.Errorf("$5204 reads $%02X, want pending and in frame", got)
+	}
+	if nes.CPU.IRQ(IRQMapper) {
+		t.Error("reading $5204 did not acknowledge the IRQ")
+	}
+	for i := 0; i < mmc5Idle; i++ {
+		m.Run()
+	}
+	if got := m.Read(0x5204); got != 0 {
+		t.Errorf("$5204 reads $%02X after the PPU stopped reading, want 0", got)
+	}
+}
+
+func TestVRC4Pins(t *testing.T) {
+	chr := make([]byte, 0x10000)
+	for i := 0; i < len(chr); i += 0x0400 {
+		chr[i] = byte(i / 0x0400)
+	}
+	c := NewCartridge(make([]byte, 0x20000), chr, 21, MirrorVertical, 0)
+	c.Submapper = 2 // VRC4c: A6 and A7
+	m := NewMapperVRC4(nil, c, vrcPins{0x40, 0x80})
+	m.Write(0xB080, 0x05) // bank 1, low nibble
+	m.Write(0xB0C0, 0x01) // bank 1, high bits
+	if got := m.Read(0x0400); got != 0x15 {
+		t.Errorf("VRC4c CHR bank 1 is $%02X, want $15", got)
+	}
+
+	c.Mapper, c.Submapper = 22, 0 // VRC2a: A1 and A0 swapped, CHR banks halved
+	m = NewMapperVRC4(nil, c, vrcPins{0x02, 0x01})
+	m.Write(0xB001, 0x0A) // bank 1, low nibble
+	if got := m.Read(0x0400); got != 0x05 {
+		t.Errorf("VRC2a CHR bank 1 is $%02X, want $05", got)
+	}
+}
+
+func TestFDSSideRoundTrip(t *testing.T) {
+	side := make([]byte, fdsSideSize)
+	copy(side, "\x01*NINTENDO-HVC*")
+	copy(side[56:], []byte{2, 1})
+	header := side[58:]
+	header[0], header[13] = 3, 4 // file header, 4 bytes of data
+	copy(side[74:], []byte{4, 0xDE, 0xAD, 0xBE, 0xEF})
+	disk := packSide(side)
+	if len(disk) != fdsDiskSize || disk[fdsLeadIn] != 0x80 {
+		t.Fatalf("packed side is %d bytes, starting with $%02X", len(disk), disk[fdsLeadIn])
+	}
+	if got := unpackSide(disk); !bytes.Equal(got, side) {
+		t.Error("unpacked side differs")
+	}
+
+	changed := append([]byte{}, side...)
+	changed[76] = 0x42
+	patch := diffIPS(side, changed)
+	if got, err := applyIPS(side, patch); err != nil || !bytes.Equal(got, changed) {
+		t.Errorf("IPS patch round trip failed: %v", err)
+	}
+	if diffIPS(side, side) != nil {
+		t.Error("IPS patch of the same data is not empty")
+	}
+}
+
+func TestMMC2Latches(t *testing.T) {
+	chr := make([]byte, 0x8000)
+	for i := 0; i < len(chr); i += 0x1000 {
+		chr[i+0x0FD8] = byte(i / 0x1000)
+		chr[i+0x0FE8] = byte(i / 0x1000)
+		chr[i+0x0FD9] = byte(i / 0x1000)
+	}
+	for _, mmc4 := range []bool{false, true} {
+		m := NewMapperMMC2(NewCartridge(make([]byte, 0x20000), chr, 9, MirrorVertical, 0), mmc4)
+		m.Write(0xB000, 1) // $FD bank of $0000
+		m.Write(0xC000, 2) // $FE bank of $0000
+		if got := m.Read(0x0FD8); got != 2 {
+			t.Errorf("mmc4 %v: tile $FD read from bank %d, want 2 until after the read", mmc4, got)
+		}
+		if got := m.Read(0x0FE8); got != 1 {
+			t.Errorf("mmc4 %v: tile $FE read from bank %d, want 1", mmc4, got)
+		}
+		m.Read(0x0FD9) // only the MMC4 sees the other rows
+		want := byte(2)
+		if mmc4 {
+			want = 1
+		}
+		if got := m.Read(0x0FD9); got != want {
+			t.Errorf("mmc4 %v: after row 1 of tile $FD, bank is %d, want %d", mmc4, got, want)
+		}
+	}
+}
+
+func TestDiscreteBoards(t *testing.T) {
+	prg := make([]byte, 0x40000)
+	for i := 0; i < len(prg); i += 0x4000 {
+		prg[i] = byte(i / 0x4000)
+	}
+	prg[1] = 0x10 // the ROM byte under a GxROM write
+	c := NewCartridge(prg, make([]byte, 0x8000), 66, MirrorVertical, 0)
+	m := NewMapperDiscrete(c, true)
+	m.Write(0x8001, 0x30) // ANDed with $10: 32KB bank 1
+	if got := m.Read(0x8000); got != 2 {
+		t.Errorf("GxROM $8000 bank is %d, want 2", got)
+	}
+
+	c.Mapper = 232
+	m = NewMapperDiscrete(c, false)
+	m.Write(0x8000, 0x08) // block 1
+	m.Write(0xC000, 0x02) // page 2
+	if lo, hi := m.Read(0x8000), m.Read(0xC000); lo != 6 || hi != 7 {
+		t.Errorf("Quattro banks are %d and %d, want 6 and 7", lo, hi)
+	}
+
+	c.Mapper, c.Submapper = 34, 1
+	m = NewMapperDiscrete(c, true)
+	m.Write(0x7FFD, 1)
+	if got := m.Read(0x8000); got != 2 {
+		t.Errorf("NINA-001 $8000 bank is %d, want 2", got)
+	}
+	if got := m.Read(0x7FFD); got != 1 {
+		t.Errorf("NINA-001 register write reads back $%02X from PRG-RAM, want $01", got)
+	}
+}
+
+func TestNamco108CHR(t *testing.T) {
+	chr := make([]byte, 0x20000)
+	for i := 0; i < len(chr); i += 0x0400 {
+		chr[i] = byte(i / 0x0400)
+	}
+	c := NewCartridge(make([]byte, 0x20000), chr, 88, MirrorVertical, 0)
+	m := NewMapperNamco108(c)
+	m.Write(0x8000, 0x00)
+	m.Write(0x8001, 0x45) // the 108 drops bit 6, then the board sets it back for the right half
+	m.Write(0x8000, 0x02)
+	m.Write(0x8001, 0x05)
+	if lo, hi := m.Read(0x0000), m.Read(0x1000); lo != 0x04 || hi != 0x45 {
+		t.Errorf("mapper 88 CHR banks are $%02X and $%02X, want $04 and $45", lo, hi)
+	}
+
+	c.Mapper = 76
+	m = NewMapperNamco108(c)
+	m.Write(0x8000, 0x03)
+	m.Write(0x8001, 0x07) // 2KB bank 7 at $0800
+	if got := m.Read(0x0C00); got != 0x0F {
+		t.Errorf("mapper 76 CHR bank at $0C00 is $%02X, want $0F", got)
+	}
+
+	c.Mapper = 95
+	m = NewMapperNamco108(c)
+	m.Write(0x8000, 0x01)
+	m.Write(0x8001, 0x20) // right nametables on CIRAM page 1
+	c.CIRAM[0x0400] = 0x95
+	if got := m.ReadNameTable(0x2800); got != 0x95 {
+		t.Errorf("mapper 95 $2800 reads $%02X, want $95 from CIRAM page 1", got)
+	}
+}
+
+func TestUNROM512Flash(t *testing.T) {
+	prg := make([]byte, 0x80000)
+	for i := range prg {
+		prg[i] = 0xFF
+	}
+	c := NewCartridge(prg, make([]byte, 0x2000), 30, MirrorVertical, 1)
+	c.CHRRAM = true
+	m := NewMapperUNROM512(c)
+	command := func(bank int, address uint16, val byte) {
+		m.Write(0xC000, byte(bank))
+		m.Write(address, val)
+	}
+	command(1, 0x9555, 0xAA)
+	command(0, 0xAAAA, 0x55)
+	command(1, 0x9555, 0xA0)
+	command(4, 0x8123, 0x5A)
+	if got := m.Read(0x8123); got != 0x5A {
+		t.Errorf("flashed byte reads $%02X, want $5A", got)
+	}
+	if save := m.(Saver).Save(); save == nil || save[4*0x4000+0x123] != 0x5A {
+		t.Error("flashed PRG is not in the save")
+	}
+	for _, val := range []byte{0xAA, 0x55, 0x80, 0xAA, 0x55} {
+		if val == 0x55 {
+			command(0, 0xAAAA, val)
+		} else {
+			command(1, 0x9555, val)
+		}
+	}
+	command(4, 0x8000, 0x30) // erase the sector at $10000
+	if got := m.Read(0x8123); got != 0xFF {
+		t.Errorf("erased byte reads $%02X, want $FF", got)
+	}
+}
+
+func TestAction53Banks(t *testing.T) {
+	prg := make([]byte, 0x40000)
+	for i := 0; i < len(prg); i += 0x4000 {
+		prg[i] = byte(i / 0x4000)
+	}
+	m := NewMapperAction53(NewCartridge(prg, make([]byte, 0x2000), 28, MirrorVertical, 0))
+	if lo, hi := m.Read(0x8000), m.Read(0xC000); lo != 14 || hi != 15 {
+		t.Errorf("power-on banks are %d and %d, want the last 32KB", lo, hi)
+	}
+	write := func(register, val byte) {
+		m.Write(0x5000, register)
+		m.Write(0x8000, val)
+	}
+	write(0x81, 2)    // outer bank: 16KB banks 4 and 5
+	write(0x80, 0x1C) // UNROM with $C000 fixed, 64KB games
+	write(0x01, 3)
+	if lo, hi := m.Read(0x8000), m.Read(0xC000); lo != 7 || hi != 5 {
+		t.Errorf("UNROM mode banks are %d and %d, want 7 and 5", lo, hi)
+	}
+}
+
+// i2c drives an EEPROM like a game does, changing SDA only while SCL is low.
+type i2c struct{ e *eeprom }
+
+func (b i2c) start() {
+	b.e.write(false, true)
+	b.e.write(true, true)
+	b.e.write(true, false)
+	b.e.write(false, false)
+}
+
+func (b i2c) stop() {
+	b.e.write(false, false)
+	b.e.write(true, false)
+	b.e.write(true, true)
+}
+
+// bit clocks out a bit and returns SDA as the EEPROM drives it.
+func (b i2c) bit(sda bool) bool {
+	b.e.write(false, sda)
+	b.e.write(true, sda)
+	out := b.e.read()
+	b.e.write(false, sda)
+	return out
+}
+
+func (b i2c) send(val byte, lsbFirst bool) bool {
+	for i := uint(0); i < 8; i++ {
+		bit := 7 - i
+		if lsbFirst {
+			bit = i
+		}
+		b.bit(val>>bit&1 != 0)
+	}
+	return !b.bit(true) // acknowledged
+}
+
+func (b i2c) receive(lsbFirst bool) byte {
+	var val byte
+	for i := uint(0); i < 8; i++ {
+		if b.bit(true) {
+			bit := 7 - i
+			if lsbFirst {
+				bit = i
+			}
+			val |= 1 << bit
+		}
+	}
+	b.bit(true) // no acknowledge: done reading
+	return val
+}
+
+func TestEEPROM(t *testing.T) {
+	b := i2c{newEEPROM(0x100)}
+	b.start()
+	if !b.send(0xA0, false) || !b.send(0x42, false) || !b.send(0x99, false) {
+		t.Fatal("24C02 did not acknowledge the write")
+	}
+	b.stop()
+	b.start()
+	b.send(0xA0, false)
+	b.send(0x42, false)
+	b.start()
+	b.send(0xA1, false)
+	if got := b.receive(false); got != 0x99 {
+		t.Errorf("24C02 reads $%02X, want $99", got)
+	}
+	b.stop()
+	if save := b.e.save(); save == nil || save[0x42] != 0x99 {
+		t.Error("24C02 write is not in the save")
+	}
+
+	b = i2c{newEEPROM(0x80)}
+	b.start()
+	b.send(0x15, true) // address $15, write
+	b.send(0x77, true)
+	b.stop()
+	b.start()
+	b.send(0x95, true) // address $15, read
+	if got := b.receive(true); got != 0x77 {
+		t.Errorf("X24C01 reads $%02X, want $77", got)
+	}
+	b.stop()
+}
+
+func TestNSFCallReturns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kuso-nes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	header := NSFFileHeader{MagicNumber: NSFMagicNumber, Magic2: 0x1A, Version: 1, Songs: 1, Start: 1,
+		Load: 0x8000, Init: 0x8000, Play: 0x8005, NTSCSpeed: 16639}
+	code := []byte{
+		0xA9, 0x12, 0x85, 0x00, 0x60, // INIT: LDA #$12, STA $00, RTS
+		0xE6, 0x01, 0x60, // PLAY: INC $01, RTS
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, header)
+	buf.Write(code)
+	path := filepath.Join(dir, "test.nsf")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n, err := NewNES(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		n.StepFrame()
+	}
+	if n.RAM[0] != 0x12 || n.RAM[1] < 8 {
+		t.Errorf("INIT wrote $%02X and PLAY ran %d times, want $12 and about 10", n.RAM[0], n.RAM[1])
+	}
+	for n.CPU.PC != nsfIdle {
+		n.Run()
+	}
+	if n.CPU.SP != 0xFD {
+		t.Errorf("back in the idle loop SP is $%02X, want $FD", n.CPU.SP)
+	}
+}
+
+func TestVRC6Audio(t *testing.T) {
+	var p vrc6Pulse
+	p.write(0, 0x3F) // duty 3, volume 15
+	p.write(2, 0x80)
+	var high int
+	for i := 0; i < 16; i++ {
+		if p.output() == 15 {
+			high++
+		}
+		p.tick(0)
+	}
+	if high != 4 {
+		t.Errorf("duty 3 is high for %d steps of 16, want 4", high)
+	}
+	p.write(0, 0xBF) // digitized mode ignores the duty
+	for i := 0; i < 16; i++ {
+		if p.output() != 15 {
+			t.Fatalf("digitized pulse is %d at step %d, want 15", p.output(), p.step)
+		}
+		p.tick(0)
+	}
+
+	var s vrc6Saw
+	s.write(0, 8)
+	s.write(2, 0x80)
+	want := []byte{0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 0}
+	for i, w := range want {
+		s.tick(0)
+		if got := s.output(); got != w {
+			t.Errorf("saw after %d clocks is %d, want %d", i+1, got, w)
+		}
+	}
+}
+
+func TestVRC7Phase(t *testing.T) {
+	var o OPLL
+	o.Reset()
+	write := func(reg, val byte) {
+		o.wAddress(reg)
+		o.wData(val)
+	}
+	// Custom instrument: modulator multiplier 1/2, carrier 2.
+	write(0x00, 0x00)
+	write(0x01, 0x02)
+	write(0x30, 0x00) // channel 0: custom instrument, full volume
+	write(0x31, 0x10) // channel 1: Buzzy bell, modulator multiplier 3
+	const fnum, block = 0x120, 4
+	for ch := byte(0); ch < 2; ch++ {
+		write(0x10+ch, fnum&0xFF)
+		write(0x20+ch, 0x10|block<<1|fnum>>8) // key on
+	}
+	const samples = 100
+	for i := 0; i < samples; i++ {
+		o.run()
+	}
+	// YM2413 application manual: F-Number = fmus * 2^18 / fsam / 2^(block-1)
+	fsam := 1789772.5 / opllCycles
+	fmus := fnum * fsam * math.Exp2(block-1) / (1 << 18)
+	tests := []struct {
+		name string
+		op   *opllOperator
+		mult float64
+	}{
+		{"custom modulator", &o.ch[0].op[0], 0.5},
+		{"custom carrier", &o.ch[0].op[1], 2},
+		{"Buzzy bell modulator", &o.ch[1].op[0], 3},
+		{"Buzzy bell carrier", &o.ch[1].op[1], 1},
+	}
+	for _, tt := range tests {
+		want := int(math.Round(samples*fmus*tt.mult/fsam*(1<<19))) % (1 << 19)
+		if tt.op.phase != want {
+			t.Errorf("%s phase is %d after %d samples, want %d", tt.name, tt.op.phase, samples, want)
+		}
+	}
+	if o.ch[1].op[1].att == 127 {
+		t.Error("Buzzy bell carrier did not attack after key on")
+	}
+}
+
+func TestN163Audio(t *testing.T) {
+	m := NewMapperN163(nil, NewCartridge(make([]byte, 0x8000), make([]byte, 0x2000), 19, MirrorVertical, 0))
+	m.Write(0xF800, 0x80) // data port at $00, auto-increment
+	m.Write(0x4800, 0x0F) // samples 15, 0
+	m.Write(0x4800, 0x00) // samples 0, 0
+	m.Write(0xF800, 0xF0) // channel 6
+	for _, val := range []byte{
+		0, 0, 0, 0, 0xFC, 0, 2, 0x0F, // 4 samples from 2, volume 15
+		0, 0, 0, 0, 0xFC, 0, 0, 0x1F, // 4 samples from 0, volume 15, two channels
+	} {
+		m.Write(0x4800, val)
+	}
+	m.Write(0xF800, 0x7F) // no auto-increment
+	if a, b := m.Read(0x4800), m.Read(0x4800); a != 0x1F || b != 0x1F {
+		t.Errorf("data port reads $%02X, $%02X without auto-increment, want $1F twice", a, b)
+	}
+	n := m.(*MapperN163)
+	tick := func() {
+		for i := 0; i < n163Cycles; i++ {
+			n.Tick()
+		}
+	}
+	// The output follows the channel being played, 6 then 7.
+	for i, want := range []int{-120, 105, -120, 105} {
+		tick()
+		if got := n.Output(); got != float32(want)*pulseTable[15]/120 {
+			t.Errorf("update %d outputs %v, want channel %d at %d", i, got, 6+i%2, want)
+		}
+	}
+	n.Mute(7, true)
+	tick()
+	if got := n.Output(); got == 0 {
+		t.Error("channel 6 silent with channel 7 muted")
+	}
+	tick()
+	if got := n.Output(); got != 0 {
+		t.Errorf("muted channel 7 outputs %v", got)
+	}
+	m.Write(0xF800, 0xFF)
+	m.Write(0x4800, 0x0F) // one channel
+	n.out[6] = 0
+	tick()
+	tick()
+	if n.out[6] != 0 {
+		t.Error("channel 6 updated with only one channel enabled")
+	}
+}
+
+func TestSunsoft5B(t *testing.T) {
+	var s sunsoft5B
+	write := func(register, val byte) {
+		s.register = register
+		s.write(val)
+	}
+	// Envelope levels after 0, 31, 32, 33 and 64 steps.
+	tests := []struct {
+		name  string
+		shape byte
+		want  [5]byte
+	}{
+		{"fall and hold", 0x00, [5]byte{31, 0, 0, 0, 0}},
+		{"rise and drop", 0x04, [5]byte{0, 31, 0, 0, 0}},
+		{"sawtooth down", 0x08, [5]byte{31, 0, 31, 30, 31}},
+		{"fall and hold", 0x09, [5]byte{31, 0, 0, 0, 0}},
+		{"triangle", 0x0A, [5]byte{31, 0, 0, 1, 31}},
+		{"fall and jump", 0x0B, [5]byte{31, 0, 31, 31, 31}},
+		{"sawtooth up", 0x0C, [5]byte{0, 31, 0, 1, 0}},
+		{"rise and hold", 0x0D, [5]byte{0, 31, 31, 31, 31}},
+		{"triangle up", 0x0E, [5]byte{0, 31, 31, 30, 0}},
+		{"rise and drop", 0x0F, [5]byte{0, 31, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		write(13, tt.shape)
+		steps := 0
+		for i, n := range [5]int{0, 31, 32, 33, 64} {
+			for ; steps < n; steps++ {
+				s.stepEnvelope()
+			}
+			if got := s.envStep ^ s.envMask; got != tt.want[i] {
+				t.Errorf("shape $%X (%s) after %d steps is %d, want %d", tt.shape, tt.name, n, got, tt.want[i])
+			}
+		}
+	}
+
+	// Tones flip and the envelope steps every 16 CPU cycles per period.
+	s = sunsoft5B{}
+	write(0, 3)
+	write(11, 2)
+	write(13, 0x0D)
+	for cycle := 1; cycle <= 48; cycle++ {
+		s.tick()
+		if flipped := cycle >= 48; s.tones[0] != flipped {
+			t.Fatalf("tone of period 3 is %v after %d cycles", s.tones[0], cycle)
+		}
+		if want := byte(cycle / 32); s.envStep != want {
+			t.Fatalf("envelope of period 2 is at step %d after %d cycles, want %d", s.envStep, cycle, want)
+		}
+	}
+}
+
+// busWatcher counts the PPU bus accesses on each scanline.
+type busWatcher struct {
+	Mapper
+	nes   *NES
+	lines [262]int
+}
+
+func (w *busWatcher) PPUAddress(address uint16) {
+	w.lines[w.nes.PPU.ScanLine]++
+}
+
+func TestPostRenderLineIdle(t *testing.T) {
+	c := NewCartridge(make([]byte, 0x8000), make([]byte, 0x2000), 0, MirrorVertical, 0)
+	nes := &NES{Cartridge: c}
+	w := &busWatcher{Mapper: NewMapper0(c), nes: nes}
+	nes.Mapper = w
+	nes.CPU = NewCPU(NewCPUMemory(nes))
+	nes.PPU = NewPPU(nes)
+	nes.PPU.wMask(0x18)
+	for i := 0; i < 262*341; i++ {
+		nes.PPU.Run()
+	}
+	if w.lines[240] != 0 {
+		t.Errorf("PPU read its bus %d times on line 240, want none", w.lines[240])
+	}
+	if w.lines[239] == 0 || w.lines[261] == 0 {
+		t.Error("PPU did not fetch on the last visible and the pre-render lines")
+	}
+}
+
+func TestNSFLoadAddress(t *testing.T) {
+	tests := []struct {
+		load  uint16
+		banks [8]byte
+		ok    bool
+	}{
+		{0x8000, [8]byte{}, true},
+		{0x6000, [8]byte{}, false},
+		{0x6000, [8]byte{0, 1, 2, 3, 4, 5, 6, 7}, true}, // bankswitched data only uses the low bits
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		header := NSFFileHeader{MagicNumber: NSFMagicNumber, Magic2: 0x1A, Version: 1, Songs: 1, Start: 1,
+			Load: tt.load, Init: 0x8000, Play: 0x8000, NTSCSpeed: 16639, Banks: tt.banks}
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, header)
+		buf.Write([]byte{0x60})
+		path := filepath.Join(dir, "test.nsf")
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadNSF(path); (err == nil) != tt.ok {
+			t.Errorf("load at $%04X, banks %v: error %v", tt.load, tt.banks, err)
+		}
+	}
+}
@@ -31,7 +31,7 @@ func (mem *CPUMemory) Read(address uint16) byte {
 		return mem.nes.Controller1.Read()
 	case address == 0x4017:
 		return mem.nes.Controller2.Read()
-	case address >= 0x6000:
+	case address >= 0x4020:
 		return mem.nes.Mapper.Read(address)
 	default:
 		log.Printf("Illegal CPU memory read at address: $%04X", address)
@@ -58,12 +58,9 @@ func (mem *CPUMemory) Write(address uint16, val byte) {
 		mem.nes.APU.WriteRegister(address, val)
 	case address < 0x4020:
 		return
-	case address >= 0x6000:
-		mem.nes.Mapper.Write(address, val)
-		return
 	default:
+		mem.nes.Mapper.Write(address, val)
 		return
-		log.Printf("Illegal CPU memory write at address: $%04X", address)
 	}
 }
 
@@ -85,12 +82,15 @@ func NewPPUMemory(nes *NES) Memory {
 }
 
 func (mem *PPUMemory) Read(address uint16) byte {
+	address %= 0x4000
+	if address < 0x3F00 {
+		mem.nes.Mapper.PPUAddress(address)
+	}
 	switch {
 	case address < 0x2000:
-		return mem.nes.Cartridge.Read(address)
+		return mem.nes.Mapper.Read(address)
 	case address < 0x3F00:
-		mode := mem.nes.Cartridge.Mirror
-		return mem.nes.PPU.nameTableData[MirrorAddress(mode, address)%2048]
+		return mem.nes.Mapper.ReadNameTable(address)
 	case address < 0x4000:
 		return mem.nes.PPU.rPalette(address % 32)
 	default:
@@ -101,13 +101,15 @@ func (mem *PPUMemory) Read(address uint16) byte {
 
 func (mem *PPUMemory) Write(address uint16, val byte) {
 	address %= 0x4000
+	if address < 0x3F00 {
+		mem.nes.Mapper.PPUAddress(address)
+	}
 	switch {
 	case address < 0x2000:
-		mem.nes.Cartridge.Write(address, val)
+		mem.nes.Mapper.Write(address, val)
 		return
 	case address < 0x3F00:
-		mode := mem.nes.Cartridge.Mirror
-		mem.nes.PPU.nameTableData[MirrorAddress(mode, address)%2048] = val
+		mem.nes.Mapper.WriteNameTable(address, val)
 		return
 	case address < 0x4000:
 		mem.nes.PPU.wPalette(address%32, val)
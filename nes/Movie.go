@@ -0,0 +1,104 @@
+package nes
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Movie playback.
+// Reads the input log of an FCEUX .fm2 movie and replays it one frame at a
+// time. Only the standard gamepads and the reset commands are supported.
+// Ref: http://www.fceux.com/web/help/fceux.html?fm2.html
+
+const (
+	movieSoftReset = 1
+	movieHardReset = 2
+)
+
+// fm2 writes the buttons of a gamepad as "RLDUTSBA".
+var movieButtons = [8]int{BRight, BLeft, BDown, BUp, BStart, BSelect, BB, BA}
+
+type movieFrame struct {
+	command byte
+	pads    [2][8]bool
+}
+
+type Movie struct {
+	Header map[string]string
+	frames []movieFrame
+	next   int
+}
+
+func LoadMovie(path string) (*Movie, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	m := Movie{Header: make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		if text[0] != '|' {
+			kv := strings.SplitN(text, " ", 2)
+			if len(kv) == 2 {
+				m.Header[kv[0]] = kv[1]
+			}
+			continue
+		}
+		fields := strings.Split(text, "|")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("Movie line %d: malformed input record", line)
+		}
+		var f movieFrame
+		command, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("Movie line %d: %v", line, err)
+		}
+		f.command = byte(command)
+		for pad := 0; pad < 2 && pad+2 < len(fields); pad++ {
+			for i, c := range fields[pad+2] {
+				if i < 8 && c != '.' && c != ' ' {
+					f.pads[pad][i] = true
+				}
+			}
+		}
+		m.frames = append(m.frames, f)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Frames returns the number of frames of input in the movie.
+func (m *Movie) Frames() int {
+	return len(m.frames)
+}
+
+// Step applies the input of the next frame to the controllers, and returns
+// false once the movie has ended.
+func (m *Movie) Step(n *NES) bool {
+	if m.next >= len(m.frames) {
+		return false
+	}
+	f := m.frames[m.next]
+	m.next++
+	if f.command&(movieSoftReset|movieHardReset) != 0 {
+		n.Reset()
+	}
+	for i, button := range movieButtons {
+		n.SetKeyPressed(1, button, f.pads[0][i])
+		n.SetKeyPressed(2, button, f.pads[1][i])
+	}
+	return true
+}
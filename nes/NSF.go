@@ -0,0 +1,254 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// NSF music files.
+// Both the classic NSF header and the chunked NSFe format are read into the
+// same structure, which MapperNSF then plays on the regular CPU and APU.
+// Ref: http://wiki.nesdev.com/w/index.php/NSF
+//      http://wiki.nesdev.com/w/index.php/NSFe
+
+const NSFMagicNumber = 0x4D53454E  // "NESM"
+const NSFeMagicNumber = 0x4546534E // "NSFE"
+
+// Play time used for tracks whose length the file doesn't give.
+const NSFDefaultDuration = 150 * time.Second
+
+type NSFFileHeader struct {
+	MagicNumber uint32   // "NESM"
+	Magic2      byte     // 0x1A
+	Version     byte     // Version number
+	Songs       byte     // Total songs
+	Start       byte     // Starting song, 1 based
+	Load        uint16   // Load address of data
+	Init        uint16   // Init address
+	Play        uint16   // Play address
+	Name        [32]byte // Null terminated strings
+	Artist      [32]byte
+	Copyright   [32]byte
+	NTSCSpeed   uint16  // Play rate in 1/1000000 sec ticks
+	Banks       [8]byte // Bankswitch init values
+	PALSpeed    uint16
+	Region      byte // Bit 0: PAL, bit 1: dual
+	Chips       byte // Extra sound chips
+	_           [4]byte
+}
+
+type NSF struct {
+	Title     string
+	Artist    string
+	Copyright string
+	Songs     int
+	Start     int // 0 based
+	Load      uint16
+	Init      uint16
+	Play      uint16
+	Speed     uint16 // NTSC play period in microseconds
+	Banks     [8]byte
+	Chips     byte
+	Tracks    []string        // Track titles, may be shorter than Songs
+	Times     []time.Duration // Track lengths, may be shorter than Songs
+	Data      []byte
+}
+
+// Bankswitched reports whether the tune uses the $5FF8-$5FFF bank registers.
+func (n *NSF) Bankswitched() bool {
+	for _, b := range n.Banks {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TrackTitle returns the title of track i, 0 based.
+func (n *NSF) TrackTitle(i int) string {
+	if i < len(n.Tracks) && n.Tracks[i] != "" {
+		return n.Tracks[i]
+	}
+	return fmt.Sprintf("%s - Track %d", n.Title, i+1)
+}
+
+// TrackDuration returns the length of track i, 0 based, or
+// NSFDefaultDuration if the file doesn't tell.
+func (n *NSF) TrackDuration(i int) time.Duration {
+	if i < len(n.Times) && n.Times[i] > 0 {
+		return n.Times[i]
+	}
+	return NSFDefaultDuration
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func IsNSF(path string) bool {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(data) < 4 {
+		return false
+	}
+	magic := binary.LittleEndian.Uint32(data)
+	return magic == NSFMagicNumber || magic == NSFeMagicNumber
+}
+
+func LoadNSF(path string) (*NSF, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, errors.New("File too short. Invalid NSF file.")
+	}
+	var nsf *NSF
+	switch binary.LittleEndian.Uint32(data) {
+	case NSFMagicNumber:
+		nsf, err = parseNSF(data)
+	case NSFeMagicNumber:
+		nsf, err = parseNSFe(data[4:])
+	default:
+		return nil, errors.New("Magic Number is Wrong. Invalid NSF file.")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Without bankswitching the data is copied to where it loads, which
+	// must be in the ROM at $8000-$FFFF.
+	if !nsf.Bankswitched() && nsf.Load < 0x8000 {
+		return nil, fmt.Errorf("NSF load address $%04X is outside $8000-$FFFF", nsf.Load)
+	}
+	if nsf.Chips != 0 {
+		log.Printf("NSF: expansion sound chips $%02X are not supported", nsf.Chips)
+	}
+	return nsf, nil
+}
+
+func parseNSF(data []byte) (*NSF, error) {
+	header := NSFFileHeader{}
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("Error in reading NSF header: %v", err)
+	}
+	nsf := NSF{
+		Title:     cString(header.Name[:]),
+		Artist:    cString(header.Artist[:]),
+		Copyright: cString(header.Copyright[:]),
+		Songs:     int(header.Songs),
+		Start:     int(header.Start) - 1,
+		Load:      header.Load,
+		Init:      header.Init,
+		Play:      header.Play,
+		Speed:     header.NTSCSpeed,
+		Banks:     header.Banks,
+		Chips:     header.Chips,
+		Data:      data[binary.Size(header):],
+	}
+	return &nsf, nil
+}
+
+func parseNSFe(data []byte) (*NSF, error) {
+	nsf := NSF{Speed: 16639}
+	info := false
+	for len(data) >= 8 {
+		size := int(binary.LittleEndian.Uint32(data))
+		id := string(data[4:8])
+		data = data[8:]
+		if size > len(data) {
+			return nil, fmt.Errorf("NSFe chunk %q is truncated", id)
+		}
+		chunk := data[:size]
+		data = data[size:]
+		switch id {
+		case "INFO":
+			if size < 9 {
+				return nil, errors.New("NSFe INFO chunk is too short")
+			}
+			nsf.Load = binary.LittleEndian.Uint16(chunk[0:])
+			nsf.Init = binary.LittleEndian.Uint16(chunk[2:])
+			nsf.Play = binary.LittleEndian.Uint16(chunk[4:])
+			nsf.Chips = chunk[7]
+			nsf.Songs = int(chunk[8])
+			if size > 9 {
+				nsf.Start = int(chunk[9])
+			}
+			info = true
+		case "DATA":
+			nsf.Data = chunk
+		case "BANK":
+			copy(nsf.Banks[:], chunk)
+		case "RATE":
+			if size >= 2 {
+				nsf.Speed = binary.LittleEndian.Uint16(chunk)
+			}
+		case "auth":
+			fields := bytes.Split(chunk, []byte{0})
+			for i, s := range []*string{&nsf.Title, &nsf.Artist, &nsf.Copyright} {
+				if i < len(fields) {
+					*s = string(fields[i])
+				}
+			}
+		case "tlbl":
+			for _, t := range bytes.Split(bytes.TrimRight(chunk, "\x00"), []byte{0}) {
+				nsf.Tracks = append(nsf.Tracks, string(t))
+			}
+		case "time":
+			for i := 0; i+4 <= size; i += 4 {
+				ms := int32(binary.LittleEndian.Uint32(chunk[i:]))
+				nsf.Times = append(nsf.Times, time.Duration(ms)*time.Millisecond)
+			}
+		case "NEND":
+			data = nil
+		default:
+			// Chunks starting with a capital letter must be understood.
+			if id[0] >= 'A' && id[0] <= 'Z' {
+				return nil, fmt.Errorf("NSFe chunk %q is not supported", id)
+			}
+		}
+	}
+	if !info || nsf.Data == nil {
+		return nil, errors.New("NSFe file without INFO or DATA chunk")
+	}
+	return &nsf, nil
+}
+
+// Cartridge lays the tune data out in 4KB banks as the player expects it.
+func (n *NSF) Cartridge() *Cartridge {
+	var prg []byte
+	if n.Bankswitched() {
+		prg = make([]byte, int(n.Load&0x0FFF)+len(n.Data))
+		copy(prg[n.Load&0x0FFF:], n.Data)
+	} else {
+		prg = make([]byte, 0x8000)
+		if n.Load >= 0x8000 {
+			copy(prg[n.Load-0x8000:], n.Data)
+		}
+	}
+	if len(prg)%0x1000 != 0 {
+		prg = append(prg, make([]byte, 0x1000-len(prg)%0x1000)...)
+	}
+	return NewCartridge(prg, make([]byte, 0x2000), 0, MirrorHorizontal, 0)
+}
+
+// Track returns the track being played, 0 based.
+func (n *NES) Track() int {
+	if m, ok := n.Mapper.(*MapperNSF); ok {
+		return m.track
+	}
+	return 0
+}
+
+// SelectTrack starts playing track i, 0 based, wrapping around at both ends.
+func (n *NES) SelectTrack(i int) {
+	if m, ok := n.Mapper.(*MapperNSF); ok {
+		m.SelectTrack(i)
+	}
+}
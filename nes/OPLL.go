@@ -0,0 +1,328 @@
+package nes
+
+import "math"
+
+// FM synthesis of the Konami VRC7
+// The VRC7 carries a cut-down Yamaha YM2413 (OPLL): six channels of two
+// operators each, a modulator feeding the phase of a carrier, no rhythm mode
+// and its own set of 15 built-in instruments. It makes a sample every 36 CPU
+// cycles, at about 49.7KHz; Output interpolates between the last two.
+// Ref: http://wiki.nesdev.com/w/index.php/VRC7_audio
+// Ref: https://github.com/digital-sound-antiques/emu2413
+
+const opllCycles = 36 // CPU cycles per sample
+
+// vrc7Patches are the built-in instruments 1-15. Instrument 0 is the custom
+// one of registers $00-$07.
+var vrc7Patches = [16][8]byte{
+	{},
+	{0x03, 0x21, 0x05, 0x06, 0xE8, 0x81, 0x42, 0x27}, // Buzzy bell
+	{0x13, 0x41, 0x14, 0x0D, 0xD8, 0xF6, 0x23, 0x12}, // Guitar
+	{0x11, 0x11, 0x08, 0x08, 0xFA, 0xB2, 0x20, 0x12}, // Wurly
+	{0x31, 0x61, 0x0C, 0x07, 0xA8, 0x64, 0x61, 0x27}, // Flute
+	{0x32, 0x21, 0x1E, 0x06, 0xE1, 0x76, 0x01, 0x28}, // Clarinet
+	{0x02, 0x01, 0x06, 0x00, 0xA3, 0xE2, 0xF4, 0xF4}, // Synth
+	{0x21, 0x61, 0x1D, 0x07, 0x82, 0x81, 0x11, 0x07}, // Trumpet
+	{0x23, 0x21, 0x22, 0x17, 0xA2, 0x72, 0x01, 0x17}, // Organ
+	{0x35, 0x11, 0x25, 0x00, 0x40, 0x73, 0x72, 0x01}, // Bells
+	{0xB5, 0x01, 0x0F, 0x0F, 0xA8, 0xA5, 0x51, 0x02}, // Vibes
+	{0x17, 0xC1, 0x24, 0x07, 0xF8, 0xF8, 0x22, 0x12}, // Vibraphone
+	{0x71, 0x23, 0x11, 0x06, 0x65, 0x74, 0x18, 0x16}, // Tutti
+	{0x01, 0x02, 0xD3, 0x05, 0xC9, 0x95, 0x03, 0x02}, // Fretless
+	{0x61, 0x63, 0x0C, 0x00, 0x94, 0xC0, 0x33, 0xF6}, // Synth bass
+	{0x21, 0x72, 0x0D, 0x00, 0xC1, 0xD5, 0x56, 0x06}, // Sweep
+}
+
+var (
+	opllLogSin [256]int // -log2 of a quarter sine wave, 8.8 fixed point
+	opllExp    [256]int // 2^-x of the fraction, 11 bits
+
+	// Key scaling in 0.375dB steps for the top 4 bits of the frequency, at
+	// 6dB an octave. It drops 6dB for each block under 7.
+	opllKSL = [16]int{0, 48, 64, 74, 80, 86, 90, 94, 96, 100, 102, 104, 106, 108, 110, 112}
+	// Twice the frequency multipliers.
+	opllMult = [16]int{1, 2, 4, 6, 8, 10, 12, 14, 16, 18, 20, 20, 24, 24, 30, 30}
+	// Vibrato, in 1/2 frequency steps for each 64 of the frequency.
+	opllPM = [8]int{0, 1, 2, 1, 0, -1, -2, -1}
+	// Envelope increments over 8 periods, by the low 2 bits of the rate.
+	opllEGSteps = [4][8]int{
+		{0, 1, 0, 1, 0, 1, 0, 1},
+		{0, 1, 0, 1, 1, 1, 0, 1},
+		{0, 1, 1, 1, 0, 1, 1, 1},
+		{0, 1, 1, 1, 1, 1, 1, 1},
+	}
+)
+
+func init() {
+	for i := range opllLogSin {
+		opllLogSin[i] = int(-math.Log2(math.Sin((float64(i)+0.5)*math.Pi/512))*256 + 0.5)
+		opllExp[i] = int(math.Exp2(-float64(i)/256)*2048 + 0.5)
+	}
+}
+
+// Envelope states
+const (
+	opllAttack = iota
+	opllDecay
+	opllSustain
+	opllRelease
+)
+
+type opllOperator struct {
+	phase int    // 19 bits a cycle
+	state int    // envelope state
+	att   int    // envelope attenuation, 0-127 in 0.375dB steps
+	out   [2]int // last two outputs, for the modulator feedback
+}
+
+type opllChannel struct {
+	fnum    int // 9 bits
+	block   int
+	key     bool
+	sustain bool
+	patch   int
+	volume  int
+	op      [2]opllOperator // modulator, carrier
+}
+
+type OPLL struct {
+	address byte
+	custom  [8]byte
+	ch      [6]opllChannel
+	muted   [6]bool
+	samples int // samples made, for the envelopes and LFOs
+	cycles  int
+	last    int
+	sample  int
+}
+
+// OPLLChannels names the VRC7 FM channels, for APU.Mute.
+var OPLLChannels = []string{"vrc7-fm1", "vrc7-fm2", "vrc7-fm3", "vrc7-fm4", "vrc7-fm5", "vrc7-fm6"}
+
+// Reset clears all the registers and silences the channels.
+func (o *OPLL) Reset() {
+	*o = OPLL{muted: o.muted}
+	for i := range o.ch {
+		o.ch[i].op[0].att = 127
+		o.ch[i].op[1].att = 127
+		o.ch[i].op[0].state = opllRelease
+		o.ch[i].op[1].state = opllRelease
+	}
+}
+
+func (o *OPLL) wAddress(val byte) {
+	o.address = val
+}
+
+func (o *OPLL) wData(val byte) {
+	reg := o.address
+	if reg < 8 {
+		o.custom[reg] = val
+		return
+	}
+	i := int(reg & 0x0F)
+	if i >= len(o.ch) {
+		return
+	}
+	c := &o.ch[i]
+	switch reg & 0xF0 {
+	case 0x10:
+		c.fnum = c.fnum&0x100 | int(val)
+	case 0x20:
+		c.fnum = c.fnum&0xFF | int(val&1)<<8
+		c.block = int(val >> 1 & 7)
+		c.sustain = val&0x20 != 0
+		key := val&0x10 != 0
+		if key && !c.key {
+			for j := range c.op {
+				c.op[j].phase = 0
+				c.op[j].state = opllAttack
+			}
+		} else if !key && c.key {
+			c.op[0].state = opllRelease
+			c.op[1].state = opllRelease
+		}
+		c.key = key
+	case 0x30:
+		c.patch = int(val >> 4)
+		c.volume = int(val & 0x0F)
+	}
+}
+
+func (o *OPLL) patch(c *opllChannel) *[8]byte {
+	if c.patch == 0 {
+		return &o.custom
+	}
+	return &vrc7Patches[c.patch]
+}
+
+// Tick runs one CPU cycle.
+func (o *OPLL) Tick() {
+	o.cycles++
+	if o.cycles == opllCycles {
+		o.cycles = 0
+		o.last = o.sample
+		o.sample = o.run()
+	}
+}
+
+// Output is the sum of the channels, -1 to 1 for each channel at full volume.
+func (o *OPLL) Output() float32 {
+	s := o.last + (o.sample-o.last)*o.cycles/opllCycles
+	return float32(s) / 2048
+}
+
+// run makes one sample.
+func (o *OPLL) run() int {
+	n := o.samples
+	o.samples++
+	// Tremolo of 4.875dB at 3.7Hz, vibrato at 6.1Hz.
+	am := n >> 9 % 26
+	if am > 13 {
+		am = 26 - am
+	}
+	pm := opllPM[n>>10&7]
+	var sum int
+	for i := range o.ch {
+		c := &o.ch[i]
+		p := o.patch(c)
+		var atts [2]int
+		for j := range c.op {
+			op := &c.op[j]
+			flags := p[j]
+			fnum := c.fnum
+			if flags&0x40 != 0 {
+				fnum += c.fnum >> 6 * pm / 2
+			}
+			op.phase = (op.phase + fnum<<uint(c.block)*opllMult[flags&0x0F]/2) & (1<<19 - 1)
+			o.envelope(c, op, p, j, n)
+			atts[j] = op.att + c.ksl(p[2+j]>>6)
+			if flags&0x80 != 0 {
+				atts[j] += am
+			}
+		}
+		atts[0] += int(p[2]&0x3F) * 2
+		atts[1] += c.volume * 8
+		m := &c.op[0]
+		var fb int
+		if p[3]&7 != 0 {
+			fb = (m.out[0] + m.out[1]) >> (8 - p[3]&7)
+		}
+		mod := opllWave(m.phase>>9+fb, atts[0], p[3]&0x08 != 0)
+		m.out = [2]int{m.out[1], mod}
+		car := opllWave(c.op[1].phase>>9+mod, atts[1], p[3]&0x10 != 0)
+		if !o.muted[i] {
+			sum += car
+		}
+	}
+	return sum
+}
+
+// ksl is the attenuation of key scaling: off, 1.5, 3 or 6dB an octave.
+func (c *opllChannel) ksl(bits byte) int {
+	if bits == 0 {
+		return 0
+	}
+	v := opllKSL[c.fnum>>5] - 16*(7-c.block)
+	if v < 0 {
+		return 0
+	}
+	return v >> (3 - bits)
+}
+
+// envelope moves operator j of channel c on sample n.
+func (o *OPLL) envelope(c *opllChannel, op *opllOperator, p *[8]byte, j, n int) {
+	flags := p[j]
+	rks := c.block<<1 | c.fnum>>8
+	if flags&0x10 == 0 {
+		rks >>= 2
+	}
+	ar := int(p[4+j] >> 4)
+	dr := int(p[4+j] & 0x0F)
+	sl := int(p[6+j]>>4) << 3
+	rr := int(p[6+j] & 0x0F)
+	percussive := flags&0x20 == 0
+	switch op.state {
+	case opllAttack:
+		if r := opllRate(ar, rks); r >= 60 {
+			op.att = 0
+		} else {
+			for i := opllEGStep(r, n); i > 0 && op.att > 0; i-- {
+				op.att -= op.att>>2 + 1
+			}
+		}
+		if op.att <= 0 {
+			op.att = 0
+			op.state = opllDecay
+		}
+	case opllDecay:
+		op.att += opllEGStep(opllRate(dr, rks), n)
+		if op.att >= sl {
+			op.state = opllSustain
+		}
+	case opllSustain:
+		if percussive {
+			op.att += opllEGStep(opllRate(rr, rks), n)
+		}
+	case opllRelease:
+		switch {
+		case c.sustain:
+			rr = 5
+		case percussive:
+			rr = 7
+		}
+		op.att += opllEGStep(opllRate(rr, rks), n)
+	}
+	if op.att > 127 {
+		op.att = 127
+	}
+}
+
+// opllRate is the envelope rate, 0-63, of a 4-bit rate and the key scaling.
+func opllRate(rate, rks int) int {
+	if rate == 0 {
+		return 0
+	}
+	r := rate*4 + rks
+	if r > 63 {
+		r = 63
+	}
+	return r
+}
+
+// opllEGStep is how far an envelope of rate r moves on sample n.
+func opllEGStep(r, n int) int {
+	if r < 4 {
+		return 0
+	}
+	shift := 13 - r>>2
+	if shift <= 0 {
+		return opllEGSteps[r&3][n&7] << uint(-shift)
+	}
+	if n&(1<<uint(shift)-1) != 0 {
+		return 0
+	}
+	return opllEGSteps[r&3][n>>uint(shift)&7]
+}
+
+// opllWave is the sine at phase, 1024 a cycle, attenuated by att 0.375dB
+// steps, -2048 to 2048. Rectified waves are silent over the second half.
+func opllWave(phase, att int, rectify bool) int {
+	i := phase & 0x3FF
+	if att >= 128 || rectify && i >= 0x200 {
+		return 0
+	}
+	q := i & 0xFF
+	if i&0x100 != 0 {
+		q = 0xFF - q
+	}
+	l := opllLogSin[q] + att<<4
+	if l >= 12<<8 {
+		return 0
+	}
+	v := opllExp[l&0xFF] >> uint(l>>8)
+	if i >= 0x200 {
+		v = -v
+	}
+	return v
+}
@@ -17,11 +17,13 @@ type PPU struct {
 	ScanLine int // 0-261
 	Frame    uint64
 
-	palette       [32]byte
-	nameTableData [2048]byte
-	oamData       [256]byte
-	front         *image.RGBA
-	back          *image.RGBA
+	palette [32]byte
+	oamData [256]byte
+	front   *image.RGBA
+	back    *image.RGBA
+	// Palette entries of the front and back pixels, for IndexedRecorder.
+	frontIndex []byte
+	backIndex  []byte
 
 	// Registers
 	v uint16
@@ -81,6 +83,8 @@ func NewPPU(nes *NES) *PPU {
 	ppu := PPU{Memory: NewPPUMemory(nes), NES: nes}
 	ppu.front = image.NewRGBA(image.Rect(0, 0, 256, 240))
 	ppu.back = image.NewRGBA(image.Rect(0, 0, 256, 240))
+	ppu.frontIndex = make([]byte, 256*240)
+	ppu.backIndex = make([]byte, 256*240)
 	ppu.Reset()
 	return &ppu
 }
@@ -120,7 +124,7 @@ func (p *PPU) Run() {
 
 	a := p.fShowBackground != 0 || p.fShowSprites != 0
 	b := p.ScanLine == 261
-	c := p.ScanLine < 241
+	c := p.ScanLine < 240 // line 240 is idle: no pixels, no fetches for mappers to count
 	d := p.Cycle >= 321 && p.Cycle <= 336
 	e := p.Cycle >= 1 && p.Cycle <= 256
 	f := b || c
@@ -145,6 +149,9 @@ func (p *PPU) Run() {
 				p.storeTileData()
 			}
 		}
+		if f && (p.Cycle == 337 || p.Cycle == 339) {
+			p.getNameTableByte() // unused fetch, but mappers see it
+		}
 		if b && p.Cycle >= 280 && p.Cycle <= 304 {
 			p.cpY()
 		}
@@ -166,6 +173,9 @@ func (p *PPU) Run() {
 				p.evaluateSprites()
 			} else {
 				p.spriteCount = 0
+				if b {
+					p.fetchEmptySprites(0)
+				}
 			}
 		}
 	}
@@ -298,6 +308,7 @@ func (p *PPU) wAddress(val byte) {
 		p.t = (p.t & 0xFF00) | uint16(val)
 		p.v = p.t
 		p.w = 0
+		p.NES.Mapper.PPUAddress(p.v % 0x4000)
 	}
 }
 
@@ -388,6 +399,8 @@ func (p *PPU) nChange() {
 
 func (p *PPU) setVerticalBlank() {
 	p.front, p.back = p.back, p.front
+	p.frontIndex, p.backIndex = p.backIndex, p.frontIndex
+	p.NES.recordFrame(p.front, p.frontIndex)
 	p.nOccurred = true
 	p.nChange()
 }
@@ -517,8 +530,9 @@ func (p *PPU) renderPixel() {
 			color = background
 		}
 	}
-	c := Palette[p.rPalette(uint16(color))%64]
-	p.back.SetRGBA(x, y, c)
+	index := p.rPalette(uint16(color)) % 64
+	p.backIndex[y*256+x] = index
+	p.back.SetRGBA(x, y, Palette[index])
 }
 
 func (p *PPU) getSpritePattern(i, row int) uint32 {
@@ -595,4 +609,20 @@ func (p *PPU) evaluateSprites() {
 		p.fSpriteOverflow = 1
 	}
 	p.spriteCount = count
+	p.fetchEmptySprites(count)
+}
+
+// fetchEmptySprites does the pattern fetches of the unused sprite slots.
+// The PPU fetches tile $FF for them, which mappers counting A12 rises rely on.
+func (p *PPU) fetchEmptySprites(used int) {
+	var address uint16
+	if p.fSpriteSize == 0 {
+		address = 0x1000*uint16(p.fSpriteTable) + 0xFF*16
+	} else {
+		address = 0x1000 + 0xFE*16
+	}
+	for i := used; i < 8; i++ {
+		p.Read(address)
+		p.Read(address + 8)
+	}
 }
@@ -4,6 +4,9 @@ import "image/color"
 
 var Palette [64]color.RGBA
 
+// paletteIndex maps a rendered color back to the first palette entry with it.
+var paletteIndex = make(map[color.RGBA]byte)
+
 func init() {
 	colors := [64]uint32{
 		// From http://nesdev.com/pal.txt
@@ -24,4 +27,12 @@ func init() {
 
 		Palette[i] = color.RGBA{R, G, B, 0xFF}
 	}
+	for i := len(Palette) - 1; i >= 0; i-- {
+		paletteIndex[Palette[i]] = byte(i)
+	}
+}
+
+// PaletteIndex returns the palette entry a frame pixel was rendered from.
+func PaletteIndex(c color.RGBA) byte {
+	return paletteIndex[c]
 }
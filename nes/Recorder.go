@@ -0,0 +1,68 @@
+package nes
+
+import "image"
+
+// Recorder receives the emulator output in emulated time: Frame is called once
+// for every frame the PPU finishes, Sample once for every sample the APU
+// produces. Nothing is dropped or duplicated when the host runs slow or fast.
+type Recorder interface {
+	Frame(frame *image.RGBA)
+	Sample(sample float32)
+	Close() error
+}
+
+// ChannelRecorder is a Recorder that samples the APU on its own clock,
+// before filtering. Channels is called SampleRate times per emulated second
+// with the mixer output and the contribution of each channel alone, in the
+// order of APUChannels.
+type ChannelRecorder interface {
+	Recorder
+	SampleRate() float64
+	Channels(mix float32, stems []float32)
+}
+
+// IndexedRecorder is a Recorder that takes frames as the palette entries the
+// PPU rendered, one byte per pixel, instead of converting colors back.
+// IndexedFrame is called in place of Frame, with a buffer only valid until
+// it returns.
+type IndexedRecorder interface {
+	Recorder
+	IndexedFrame(pix []byte)
+}
+
+func (n *NES) AddRecorder(r Recorder) {
+	n.recorders = append(n.recorders, r)
+	if c, ok := r.(ChannelRecorder); ok {
+		n.APU.addTap(c)
+	}
+}
+
+// RemoveRecorder detaches r and closes it.
+func (n *NES) RemoveRecorder(r Recorder) error {
+	for i := range n.recorders {
+		if n.recorders[i] == r {
+			n.recorders = append(n.recorders[:i], n.recorders[i+1:]...)
+			break
+		}
+	}
+	if c, ok := r.(ChannelRecorder); ok {
+		n.APU.removeTap(c)
+	}
+	return r.Close()
+}
+
+func (n *NES) recordFrame(frame *image.RGBA, pix []byte) {
+	for _, r := range n.recorders {
+		if r, ok := r.(IndexedRecorder); ok {
+			r.IndexedFrame(pix)
+		} else {
+			r.Frame(frame)
+		}
+	}
+}
+
+func (n *NES) recordSample(sample float32) {
+	for _, r := range n.recorders {
+		r.Sample(sample)
+	}
+}
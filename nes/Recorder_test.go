@@ -0,0 +1,399 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testFrame is a frame of palette indices: a background of color, a
+// stripe of $0D on row 10 and a pixel of $20 at the top left.
+func testFrame(color byte) []byte {
+	pix := make([]byte, 256*240)
+	for i := range pix {
+		pix[i] = color
+	}
+	for x := 0; x < 256; x++ {
+		pix[10*256+x] = 0x0D
+	}
+	pix[0] = 0x20
+	return pix
+}
+
+// testImage is pix as the PPU draws it.
+func testImage(pix []byte) *image.RGBA {
+	im := image.NewRGBA(image.Rect(0, 0, 256, 240))
+	for i, c := range pix {
+		im.SetRGBA(i%256, i/256, Palette[c])
+	}
+	return im
+}
+
+// decodeRLE8 expands an msrle frame, stored bottom row first.
+func decodeRLE8(data []byte) ([]byte, error) {
+	pix := make([]byte, 0, 256*240)
+	var row []byte
+	for i := 0; i+1 < len(data); i += 2 {
+		n, c := data[i], data[i+1]
+		if n > 0 {
+			row = append(row, bytes.Repeat([]byte{c}, int(n))...)
+			continue
+		}
+		if len(row) != 256 {
+			return nil, fmt.Errorf("row of %d pixels", len(row))
+		}
+		pix = append(row, pix...)
+		row = nil
+		if c == 1 {
+			return pix, nil
+		}
+	}
+	return nil, errors.New("no end of bitmap")
+}
+
+func TestAVIRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.avi")
+	r, err := NewAVIRecorder(path, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames := [][]byte{testFrame(0x21), testFrame(0x16), testFrame(0x21)}
+	counts := []int{100, 201, 0} // samples of each frame
+	for i, pix := range frames {
+		for j := 0; j < counts[i]; j++ {
+			r.Sample(0.5)
+		}
+		if i == 2 {
+			r.Frame(testImage(pix)) // converted back, as if not from the PPU
+		} else {
+			r.IndexedFrame(pix)
+		}
+	}
+	r.Sample(0.5) // after the last frame, dropped
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		t.Fatalf("file starts with %q", data[:12])
+	}
+	if size := le.Uint32(data[4:]); int(size) != len(data)-8 {
+		t.Errorf("RIFF size is %d, want %d", size, len(data)-8)
+	}
+
+	// The top level chunks must add up to the file: hdrl, movi, idx1.
+	chunks := map[string]int{} // offset of each list or chunk, by name
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			t.Fatalf("chunk header at %d runs past the end", pos)
+		}
+		id, size := string(data[pos:pos+4]), int(le.Uint32(data[pos+4:]))
+		if id == "LIST" {
+			id = string(data[pos+8 : pos+12])
+		}
+		chunks[id] = pos
+		pos += 8 + size + size%2
+		if pos > len(data) {
+			t.Fatalf("%s chunk of %d bytes runs past the end", id, size)
+		}
+	}
+	movi, ok1 := chunks["movi"]
+	idx1, ok2 := chunks["idx1"]
+	if _, ok := chunks["hdrl"]; !ok || !ok1 || !ok2 {
+		t.Fatalf("top level chunks are %v, want hdrl, movi and idx1", chunks)
+	}
+
+	var main aviMainHeader
+	binary.Read(bytes.NewReader(data[r.offsets["avih"]:]), le, &main)
+	var video, audio aviStreamHeader
+	binary.Read(bytes.NewReader(data[r.offsets["video"]:]), le, &video)
+	binary.Read(bytes.NewReader(data[r.offsets["audio"]:]), le, &audio)
+	if main.TotalFrames != 3 || video.Length != 3 || audio.Length != 301 {
+		t.Errorf("headers count %d frames, %d video frames and %d samples, want 3, 3 and 301",
+			main.TotalFrames, video.Length, audio.Length)
+	}
+
+	// Every index entry points at its chunk, relative to the movi list type.
+	entries := int(le.Uint32(data[idx1+4:])) / 16
+	if entries != 6 {
+		t.Fatalf("index has %d entries, want 6", entries)
+	}
+	var videoChunks [][]byte
+	for i := 0; i < entries; i++ {
+		var e aviIndexEntry
+		binary.Read(bytes.NewReader(data[idx1+8+i*16:]), le, &e)
+		pos := movi + 8 + int(e.Offset)
+		if string(data[pos:pos+4]) != string(e.ID[:]) || le.Uint32(data[pos+4:]) != e.Size {
+			t.Fatalf("index entry %d (%s, %d bytes) does not match the chunk at %d", i, e.ID, e.Size, pos)
+		}
+		chunk := data[pos+8 : pos+8+int(e.Size)]
+		switch string(e.ID[:]) {
+		case "00dc":
+			videoChunks = append(videoChunks, chunk)
+		case "01wb":
+			if want := counts[i/2] * 2; len(chunk) != want {
+				t.Errorf("audio chunk %d is %d bytes, want %d", i/2, len(chunk), want)
+			}
+			if len(chunk) > 0 && int16(le.Uint16(chunk)) != 16383 {
+				t.Errorf("audio chunk %d starts with %d, want 16383", i/2, int16(le.Uint16(chunk)))
+			}
+		}
+	}
+	for i, chunk := range videoChunks {
+		pix, err := decodeRLE8(chunk)
+		if err != nil {
+			t.Fatalf("video chunk %d: %v", i, err)
+		}
+		if !bytes.Equal(pix, frames[i]) {
+			t.Errorf("video chunk %d does not decode to the frame", i)
+		}
+	}
+}
+
+// vgmSong feeds r an intro of distinct frames, then passes of a loop whose
+// last rest frames are silent, then silent frames.
+func vgmSong(r *VGMRecorder, intro, loop, rest, passes, silence int) {
+	var cycle uint64
+	frame := func() {
+		cycle += 29781
+		r.Frame(nil)
+	}
+	frame()
+	for i := 0; i < intro; i++ {
+		r.Register(cycle, 0x4000, byte(i))
+		frame()
+	}
+	for p := 0; p < passes; p++ {
+		for i := 0; i < loop; i++ {
+			if i < loop-rest {
+				r.Register(cycle, 0x4002, byte(i))
+				r.Register(cycle, 0x4003, byte(i>>8))
+			}
+			frame()
+		}
+	}
+	for i := 0; i < silence; i++ {
+		frame()
+	}
+}
+
+func TestVGMDetectLoop(t *testing.T) {
+	tests := []struct {
+		name                               string
+		intro, loop, rest, passes, silence int
+		start, period                      int
+	}{
+		// Frame 50 starts with the intro's $4002, the loop from 51 on.
+		{"loop", 50, 150, 0, 3, 0, 51, 150},
+		{"loop ending in a rest", 50, 150, 40, 3, 0, 51, 150},
+		{"ends in silence", 50, 150, 0, 3, 300, -1, 0},
+		{"ends in minutes of silence", 50, 150, 0, 3, 20000, -1, 0},
+		{"no repeat", 400, 150, 0, 1, 0, -1, 0},
+		{"too short", 50, 60, 0, 3, 0, -1, 0},
+	}
+	for _, tt := range tests {
+		r := NewVGMRecorder("", true)
+		vgmSong(r, tt.intro, tt.loop, tt.rest, tt.passes, tt.silence)
+		start, period := r.detectLoop()
+		if start != tt.start || start >= 0 && period != tt.period {
+			t.Errorf("%s: loop at frame %d with period %d, want %d and %d", tt.name, start, period, tt.start, tt.period)
+		}
+	}
+}
+
+func TestGIFRecorder(t *testing.T) {
+	for _, halfRate := range []bool{false, true} {
+		skip := 1
+		if halfRate {
+			skip = 2
+		}
+		path := filepath.Join(t.TempDir(), "test.gif")
+		// Room for 3 frames, fed 7: the clip keeps the last 3 taken.
+		r := NewGIFRecorder(path, 2.5*float64(skip)/FrameRate, halfRate)
+		var frames [][]byte
+		for i := 0; i < 7; i++ {
+			pix := testFrame(0x11 + byte(i))
+			pix[1] = 0x0F // the same black as $0D
+			pix[2] = 0x30 // the same white as $20
+			frames = append(frames, pix)
+			if i == 6 {
+				r.Frame(testImage(pix))
+			} else {
+				r.IndexedFrame(pix)
+			}
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := gif.DecodeAll(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []int{4, 5, 6}
+		if halfRate {
+			want = []int{2, 4, 6}
+		}
+		if len(g.Image) != len(want) {
+			t.Fatalf("half rate %v: clip has %d frames, want %d", halfRate, len(g.Image), len(want))
+		}
+		delay := 0
+		for i, im := range g.Image {
+			delay += g.Delay[i]
+			// Black, white, and the background colors of the 3 frames. The
+			// encoder pads the palette to a power of 2.
+			colors := map[color.Color]bool{}
+			for _, c := range im.Palette {
+				colors[color.RGBAModel.Convert(c)] = true
+			}
+			if len(colors) != 5 {
+				t.Errorf("half rate %v: frame %d palette has %d colors, want 5", halfRate, i, len(colors))
+			}
+			pix := frames[want[i]]
+			for j, c := range pix {
+				if got := color.RGBAModel.Convert(im.At(j%256, j/256)); got != Palette[c] {
+					t.Fatalf("half rate %v: frame %d pixel %d is %v, want %v", halfRate, i, j, got, Palette[c])
+				}
+			}
+		}
+		if want := int(math.Round(3 * 100 * float64(skip) / FrameRate)); delay != want {
+			t.Errorf("half rate %v: clip lasts %d/100 s, want %d", halfRate, delay, want)
+		}
+	}
+}
+
+func TestWAVRecorder(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "game.wav")
+	r, err := NewWAVRecorder(path, 8000, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stems := make([]float32, len(APUChannels))
+	for i := range stems {
+		stems[i] = float32(i+1) / 10
+	}
+	const samples = 101
+	for i := 0; i < samples; i++ {
+		r.Channels(0.25, stems)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{path}
+	want := []float32{0.25}
+	for i, name := range APUChannels {
+		files = append(files, filepath.Join(dir, "game-"+name+".wav"))
+		want = append(want, stems[i])
+	}
+	le := binary.LittleEndian
+	for i, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 44+samples*2 {
+			t.Fatalf("%s is %d bytes, want %d", file, len(data), 44+samples*2)
+		}
+		if string(data[0:4]) != "RIFF" || string(data[8:16]) != "WAVEfmt " || string(data[36:40]) != "data" {
+			t.Fatalf("%s has the chunks %q", file, data[:40])
+		}
+		if size := le.Uint32(data[4:]); int(size) != len(data)-8 {
+			t.Errorf("%s: RIFF size is %d, want %d", file, size, len(data)-8)
+		}
+		if size := le.Uint32(data[40:]); size != samples*2 {
+			t.Errorf("%s: data size is %d, want %d", file, size, samples*2)
+		}
+		if rate, byteRate := le.Uint32(data[24:]), le.Uint32(data[28:]); rate != 8000 || byteRate != 16000 {
+			t.Errorf("%s: %d samples and %d bytes per second, want 8000 and 16000", file, rate, byteRate)
+		}
+		v := int16(want[i] * 32767)
+		for j := 0; j < samples; j++ {
+			if got := int16(le.Uint16(data[44+j*2:])); got != v {
+				t.Fatalf("%s: sample %d is %d, want %d", file, j, got, v)
+			}
+		}
+	}
+}
+
+func TestVGMRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.vgm")
+	r := NewVGMRecorder(path, true)
+	vgmSong(r, 50, 150, 0, 3, 0)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	field := func(offset int) int { return int(le.Uint32(data[offset:])) }
+	if string(data[:4]) != "Vgm " || field(0x08) != vgmVersion {
+		t.Fatalf("header starts with %q, version $%X", data[:4], field(0x08))
+	}
+	if eof := 0x04 + field(0x04); eof != len(data) {
+		t.Errorf("EOF offset points at %d, file is %d bytes", eof, len(data))
+	}
+	if start := 0x34 + field(0x34); start != vgmHeaderSize || !bytes.Equal(data[start:start+3], []byte{vgmWriteAPU, 0x00, 0}) {
+		t.Errorf("data offset points at %d, want the first write at %d", start, vgmHeaderSize)
+	}
+	// Frame k of vgmSong writes on its start, k frames after the first write.
+	samples := func(frame int) int { return frame * 29781 * vgmRate / CPUFrequency }
+	// The loop is frames 51 to 200, and the file ends after it.
+	if total := field(0x18); total != samples(200) {
+		t.Errorf("total samples %d, want %d", total, samples(200))
+	}
+	if loop := field(0x20); loop != samples(200)-samples(50) {
+		t.Errorf("loop samples %d, want %d", loop, samples(200)-samples(50))
+	}
+	loop := 0x1C + field(0x1C)
+	if loop >= len(data) || data[loop] != vgmWait {
+		t.Fatalf("loop offset points at %d, want the wait before frame 51", loop)
+	}
+	if got := data[loop+3 : loop+6]; !bytes.Equal(got, []byte{vgmWriteAPU, 0x02, 1}) {
+		t.Errorf("loop starts with % X, want the $4002 write of loop frame 1", got)
+	}
+	if end := vgmHeaderSize + r.frames[201].offset; len(data) != end+1 || data[end] != vgmEnd {
+		t.Errorf("file is %d bytes, want the end command at %d", len(data), end)
+	}
+
+	// DMC bytes go out as a RAM write block, once.
+	path = filepath.Join(t.TempDir(), "dmc.vgm")
+	r = NewVGMRecorder(path, false)
+	r.Register(0, 0x4010, 0x0F)
+	r.DMCRead(0, 0xC000, 0x55)
+	r.DMCRead(8, 0xC001, 0xAA)
+	r.DMCRead(16, 0xC000, 0x55)
+	r.Frame(nil)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err = ioutil.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{vgmWriteAPU, 0x10, 0x0F, vgmDataBlock, vgmEnd, vgmNESRAM, 4, 0, 0, 0, 0x00, 0xC0, 0x55, 0xAA, vgmEnd}
+	if got := data[vgmHeaderSize:]; !bytes.Equal(got, want) {
+		t.Errorf("DMC log is % X, want % X", got, want)
+	}
+	if field(0x1C) != 0 {
+		t.Error("loop offset set without a loop")
+	}
+}
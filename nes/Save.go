@@ -0,0 +1,61 @@
+package nes
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Saver is a mapper with memory that outlives the power, like self-flashed
+// PRG or an EEPROM. It is kept in a .sav file next to the game.
+type Saver interface {
+	// LoadSave restores the memory from the contents of the save file.
+	LoadSave(data []byte) error
+	// Save returns the memory to write to the save file, or nil if the game
+	// never changed it.
+	Save() []byte
+}
+
+func (n *NES) savePath() string {
+	return strings.TrimSuffix(n.FileName, filepath.Ext(n.FileName)) + ".sav"
+}
+
+// loadSave restores the memory of a Saver mapper from its save file, if the
+// game has one.
+func (n *NES) loadSave() error {
+	s, ok := n.Mapper.(Saver)
+	if !ok {
+		return nil
+	}
+	data, err := ioutil.ReadFile(n.savePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		err = s.LoadSave(data)
+	}
+	if err != nil {
+		return fmt.Errorf("Error in loading %v: %v", n.savePath(), err)
+	}
+	return nil
+}
+
+// Save writes what the game keeps across power cycles next to it: the
+// changes to the disk of a Disk System game, or the memory of a Saver
+// mapper. It does nothing for other games.
+func (n *NES) Save() error {
+	if n.FDS != nil {
+		return n.FDS.Save()
+	}
+	s, ok := n.Mapper.(Saver)
+	if !ok {
+		return nil
+	}
+	data := s.Save()
+	if data == nil {
+		return nil
+	}
+	return ioutil.WriteFile(n.savePath(), data, 0644)
+}
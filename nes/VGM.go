@@ -0,0 +1,260 @@
+package nes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/fnv"
+	"image"
+	"os"
+)
+
+// VGM recorder.
+// Logs every APU register write, and the DMC sample bytes as RAM writes, in
+// the VGM 1.71 NES APU command set. The log is kept in memory and written on
+// Close, when the loop point is set: either where MarkLoop was called, or,
+// with loop detection on, at the start of the stretch of frames that repeats
+// until the end of the recording. In that case the file ends after one pass
+// of the loop.
+// Ref: https://vgmrips.net/wiki/VGM_Specification
+
+const (
+	vgmRate       = 44100 // VGM timestamps are always in 1/44100 s
+	vgmHeaderSize = 0x100
+	vgmVersion    = 0x171
+	vgmWriteAPU   = 0xB4
+	vgmWait       = 0x61
+	vgmDataBlock  = 0x67
+	vgmEnd        = 0x66
+	vgmNESRAM     = 0xC2   // RAM write data block for the NES APU
+	vgmMinLoop    = 2 * 60 // shortest loop accepted by detection, in frames
+	vgmLoopWindow = 60     // frames compared at once by detection
+)
+
+// The 64-bit FNV prime, to hash windows of frame hashes.
+const vgmWindowBase = 1099511628211
+
+// RegisterRecorder is a Recorder that logs what the APU is told to play:
+// every register write and every byte the DMC fetches, with the APU cycle
+// it happened on.
+type RegisterRecorder interface {
+	Recorder
+	Register(cycle uint64, address uint16, val byte)
+	DMCRead(cycle uint64, address uint16, val byte)
+}
+
+type vgmFrame struct {
+	offset  int        // position of the frame's first command
+	samples uint32     // timestamp of the frame start
+	regs    [0x18]byte // register state at the frame start
+	hash    uint64     // regs plus the frame's commands, without the waits
+	active  bool       // the frame has register writes or DMC data
+}
+
+type VGMRecorder struct {
+	path    string
+	detect  bool
+	loop    int // frame to loop back to, -1 for none
+	mark    bool
+	data    bytes.Buffer
+	samples uint32 // samples written so far
+	start   uint64 // APU cycle of the first event
+	started bool
+	regs    [0x18]byte
+	frames  []vgmFrame
+	hash    hash.Hash64   // commands of the current frame
+	active  bool          // the current frame has commands
+	dmc     [0x8000]int16 // sample bytes already sent, -1 if none
+	block   []byte        // pending DMC bytes
+	blockAt uint16
+}
+
+// NewVGMRecorder logs the APU to path, written on Close. detectLoop turns on
+// automatic loop detection.
+func NewVGMRecorder(path string, detectLoop bool) *VGMRecorder {
+	r := VGMRecorder{path: path, detect: detectLoop, loop: -1, hash: fnv.New64a()}
+	for i := range r.dmc {
+		r.dmc[i] = -1
+	}
+	return &r
+}
+
+// MarkLoop sets the loop point at the start of the next frame.
+func (r *VGMRecorder) MarkLoop() {
+	r.mark = true
+}
+
+// wait advances the log to the APU cycle.
+func (r *VGMRecorder) wait(cycle uint64) {
+	if !r.started {
+		r.start = cycle
+		r.started = true
+	}
+	now := uint32((cycle - r.start) * vgmRate / CPUFrequency)
+	for r.samples < now {
+		n := now - r.samples
+		if n > 0xFFFF {
+			n = 0xFFFF
+		}
+		if n <= 16 {
+			r.data.WriteByte(byte(0x70 + n - 1))
+		} else {
+			r.data.WriteByte(vgmWait)
+			binary.Write(&r.data, binary.LittleEndian, uint16(n))
+		}
+		r.samples += n
+	}
+}
+
+// flush sends the pending DMC bytes as one data block.
+func (r *VGMRecorder) flush() {
+	if len(r.block) == 0 {
+		return
+	}
+	r.data.Write([]byte{vgmDataBlock, vgmEnd, vgmNESRAM})
+	binary.Write(&r.data, binary.LittleEndian, uint32(len(r.block)+2))
+	binary.Write(&r.data, binary.LittleEndian, r.blockAt)
+	r.data.Write(r.block)
+	r.hash.Write(r.block)
+	r.active = true
+	r.block = r.block[:0]
+}
+
+func (r *VGMRecorder) Register(cycle uint64, address uint16, val byte) {
+	if address < 0x4000 || address > 0x4017 {
+		return
+	}
+	r.flush()
+	r.wait(cycle)
+	command := []byte{vgmWriteAPU, byte(address - 0x4000), val}
+	r.data.Write(command)
+	r.hash.Write(command)
+	r.active = true
+	r.regs[address-0x4000] = val
+}
+
+func (r *VGMRecorder) DMCRead(cycle uint64, address uint16, val byte) {
+	if address < 0x8000 || r.dmc[address-0x8000] == int16(val) {
+		return
+	}
+	r.dmc[address-0x8000] = int16(val)
+	if len(r.block) > 0 && r.blockAt+uint16(len(r.block)) != address {
+		r.flush()
+	}
+	if len(r.block) == 0 {
+		r.wait(cycle)
+		r.blockAt = address
+	}
+	r.block = append(r.block, val)
+}
+
+func (r *VGMRecorder) Frame(frame *image.RGBA) {
+	r.flush()
+	if len(r.frames) > 0 {
+		f := &r.frames[len(r.frames)-1]
+		r.hash.Write(f.regs[:])
+		f.hash = r.hash.Sum64()
+		f.active = r.active
+		r.hash.Reset()
+	}
+	r.active = false
+	if r.mark {
+		r.loop = len(r.frames)
+		r.mark = false
+	}
+	r.frames = append(r.frames, vgmFrame{offset: r.data.Len(), samples: r.samples, regs: r.regs})
+}
+
+func (r *VGMRecorder) Sample(sample float32) {
+}
+
+// detectLoop finds the shortest period with which the recording repeats
+// until the end, and returns the earliest frame the repetition reaches back
+// to with the period, or -1 if there is none. Frames without commands look
+// alike, so the search is anchored on the window of frames ending at the
+// last one with commands: only the earlier places where that window is
+// found again are tried, from the nearest. A recording that ends in more
+// silence than a period never loops.
+func (r *VGMRecorder) detectLoop() (int, int) {
+	n := len(r.frames) - 1 // the last frame was cut short
+	last := n - 1
+	for last >= 0 && !r.frames[last].active {
+		last--
+	}
+	w := vgmLoopWindow
+	if last+1 < w {
+		return -1, 0
+	}
+	// Rolling hashes of the windows of w frame hashes.
+	var top uint64 = 1 // vgmWindowBase^(w-1)
+	for i := 1; i < w; i++ {
+		top *= vgmWindowBase
+	}
+	windows := make([]uint64, last-w+2)
+	var h uint64
+	for i := 0; i <= last; i++ {
+		if i >= w {
+			h -= r.frames[i-w].hash * top
+		}
+		h = h*vgmWindowBase + r.frames[i].hash
+		if i >= w-1 {
+			windows[i-w+1] = h
+		}
+	}
+	anchor := last - w + 1
+	for j := anchor - vgmMinLoop; j >= 0; j-- {
+		if windows[j] != windows[anchor] {
+			continue
+		}
+		p := anchor - j
+		k := 0
+		for k < n-p && r.frames[n-1-k].hash == r.frames[n-1-k-p].hash {
+			k++
+		}
+		if k < p || k < n-last {
+			continue
+		}
+		return n - k - p, p
+	}
+	return -1, 0
+}
+
+func (r *VGMRecorder) Close() error {
+	r.flush()
+	end := r.data.Len()
+	total := r.samples
+	loop := r.loop
+	if loop < 0 && r.detect {
+		start, period := r.detectLoop()
+		if start >= 0 {
+			loop = start
+			end = r.frames[start+period].offset
+			total = r.frames[start+period].samples
+		}
+	}
+
+	body := append(r.data.Bytes()[:end:end], vgmEnd)
+	header := make([]byte, vgmHeaderSize)
+	copy(header, "Vgm ")
+	le := binary.LittleEndian
+	le.PutUint32(header[0x04:], uint32(vgmHeaderSize+len(body)-0x04))
+	le.PutUint32(header[0x08:], vgmVersion)
+	le.PutUint32(header[0x18:], total)
+	if loop >= 0 && loop < len(r.frames) {
+		le.PutUint32(header[0x1C:], uint32(vgmHeaderSize+r.frames[loop].offset-0x1C))
+		le.PutUint32(header[0x20:], total-r.frames[loop].samples)
+	}
+	le.PutUint32(header[0x24:], 60)
+	le.PutUint32(header[0x34:], vgmHeaderSize-0x34)
+	le.PutUint32(header[0x84:], CPUFrequency)
+
+	file, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(header, body...)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
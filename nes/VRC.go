@@ -0,0 +1,57 @@
+package nes
+
+// IRQ counter of the Konami VRC4, VRC6 and VRC7.
+// An 8-bit counter counts up to $FF and reloads from the latch, raising the
+// IRQ. In scanline mode a prescaler clocks it every 341/3 CPU cycles, in
+// cycle mode every CPU cycle.
+// Ref: http://wiki.nesdev.com/w/index.php/VRC_IRQ
+
+type vrcIRQ struct {
+	latch     byte
+	counter   byte
+	prescaler int
+	enable    bool
+	enableAck bool // enable after acknowledge
+	cycleMode bool
+	pending   bool
+}
+
+func (v *vrcIRQ) wLatch(val byte) {
+	v.latch = val
+}
+
+func (v *vrcIRQ) wControl(val byte) {
+	v.enableAck = val&1 != 0
+	v.enable = val&2 != 0
+	v.cycleMode = val&4 != 0
+	if v.enable {
+		v.counter = v.latch
+		v.prescaler = 341
+	}
+	v.pending = false
+}
+
+func (v *vrcIRQ) ack() {
+	v.pending = false
+	v.enable = v.enableAck
+}
+
+// tick runs one CPU cycle.
+func (v *vrcIRQ) tick() {
+	if !v.enable {
+		return
+	}
+	if !v.cycleMode {
+		v.prescaler -= 3
+		if v.prescaler > 0 {
+			return
+		}
+		v.prescaler += 341
+	}
+	if v.counter == 0xFF {
+		v.counter = v.latch
+		v.pending = true
+	} else {
+		v.counter++
+	}
+}
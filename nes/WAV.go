@@ -0,0 +1,151 @@
+package nes
+
+import (
+	"bufio"
+	"encoding/binary"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WAV recorder.
+// Samples the APU at its own rate, independent of the audio device, so every
+// sample reaches the file. The mix can be taken before or after the output
+// filters, and each channel can additionally be written to its own file.
+
+// wavFile is a 16-bit mono PCM wave file whose sizes are fixed up on close.
+type wavFile struct {
+	file    *os.File
+	w       *bufio.Writer
+	rate    int
+	samples uint32
+	filter  FilterChain
+}
+
+func createWAV(path string, rate int, filter FilterChain) (*wavFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	f := wavFile{file: file, w: bufio.NewWriter(file), rate: rate, filter: filter}
+	if err := f.header(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &f, nil
+}
+
+func (f *wavFile) header() error {
+	size := f.samples * 2
+	for _, v := range []interface{}{
+		fourCC("RIFF"), 36 + size, fourCC("WAVE"),
+		fourCC("fmt "), uint32(16),
+		uint16(1), uint16(1), uint32(f.rate), uint32(f.rate * 2), uint16(2), uint16(16),
+		fourCC("data"), size,
+	} {
+		if err := binary.Write(f.w, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *wavFile) write(sample float32) error {
+	sample = f.filter.Run(sample)
+	v := int16(math.Max(-1, math.Min(1, float64(sample))) * 32767)
+	f.samples++
+	return binary.Write(f.w, binary.LittleEndian, v)
+}
+
+func (f *wavFile) close() error {
+	if err := f.w.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if _, err := f.file.Seek(0, 0); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.header(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.w.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+type WAVRecorder struct {
+	rate  int
+	mix   *wavFile
+	stems []*wavFile
+	err   error // first write error, reported by Close
+}
+
+// NewWAVRecorder records the APU output to path at sampleRate. If filtered is
+// set the output filters are applied, otherwise the raw mixer level is kept.
+// With stems each channel is also written alone, to path with the channel
+// name appended (game-square1.wav, ...).
+func NewWAVRecorder(path string, sampleRate int, filtered, stems bool) (*WAVRecorder, error) {
+	r := WAVRecorder{rate: sampleRate}
+	chain := func() FilterChain {
+		if filtered {
+			return NewFilterChain(float32(sampleRate))
+		}
+		return nil
+	}
+	mix, err := createWAV(path, sampleRate, chain())
+	if err != nil {
+		return nil, err
+	}
+	r.mix = mix
+	if stems {
+		ext := filepath.Ext(path)
+		base := strings.TrimSuffix(path, ext)
+		for _, name := range APUChannels {
+			stem, err := createWAV(base+"-"+name+ext, sampleRate, chain())
+			if err != nil {
+				r.Close()
+				return nil, err
+			}
+			r.stems = append(r.stems, stem)
+		}
+	}
+	return &r, nil
+}
+
+func (r *WAVRecorder) SampleRate() float64 {
+	return float64(r.rate)
+}
+
+func (r *WAVRecorder) Channels(mix float32, stems []float32) {
+	if r.err != nil {
+		return
+	}
+	r.err = r.mix.write(mix)
+	for i, f := range r.stems {
+		if r.err == nil {
+			r.err = f.write(stems[i])
+		}
+	}
+}
+
+func (r *WAVRecorder) Frame(frame *image.RGBA) {
+}
+
+func (r *WAVRecorder) Sample(sample float32) {
+}
+
+func (r *WAVRecorder) Close() error {
+	err := r.err
+	for _, f := range append([]*wavFile{r.mix}, r.stems...) {
+		if e := f.close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
 )
@@ -16,8 +17,24 @@ type NESFileHeader struct {
 	CHRNum      byte    // CHR-ROM banks number
 	Ctrl1       byte    // Control
 	Ctrl2       byte    // Control too
-	RAMNum      byte    // RAM number (8KB each)
-	_           [7]byte // Empty bytes. Not used at this tume but MUST BE ALL ZEROS or games will not work.
+	RAMNum      byte    // RAM number (8KB each). NES 2.0: mapper bits 8-11 and submapper
+	ROMNum      byte    // NES 2.0: PRG-ROM (low nibble) and CHR-ROM bank number bits 8-11
+	PRGRAMShift byte    // NES 2.0: PRG-RAM (low nibble) and PRG-NVRAM size, 64<<n bytes
+	CHRRAMShift byte    // NES 2.0: CHR-RAM (low nibble) and CHR-NVRAM size, 64<<n bytes
+	_           [4]byte // Empty bytes. Not used at this tume but MUST BE ALL ZEROS or games will not work.
+}
+
+// NES2 reports whether the header is in the NES 2.0 format.
+func (h *NESFileHeader) NES2() bool {
+	return h.Ctrl2&0x0C == 0x08
+}
+
+// ramSize decodes a NES 2.0 RAM size nibble.
+func ramSize(shift byte) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
 }
 
 /*
@@ -45,13 +62,19 @@ func LoadNES(path string) (*Cartridge, error) {
 		return nil, errors.New("Magic Number is Wrong.Invilid iNES file.")
 	}
 
-	mapper1 := header.Ctrl1 >> 4
-	mapper2 := header.Ctrl2 >> 4
+	mapper1 := uint16(header.Ctrl1 >> 4)
+	mapper2 := uint16(header.Ctrl2 >> 4)
 	mapper := mapper1 | mapper2<<4
+	var submapper byte
+	if header.NES2() {
+		mapper |= uint16(header.RAMNum&0x0F) << 8
+		submapper = header.RAMNum >> 4
+	}
 
-	mirror1 := header.Ctrl1 & 1
-	mirror2 := header.Ctrl1 >> 3 & 1
-	mirror := mirror1 | mirror2<<1
+	mirror := header.Ctrl1 & 1
+	if header.Ctrl1&0x8 == 0x8 {
+		mirror = MirrorFour
+	}
 
 	battery := header.Ctrl1 >> 1 & 1
 
@@ -65,24 +88,63 @@ func LoadNES(path string) (*Cartridge, error) {
 
 	// PRG -- 16 KB each
 
-	prg := make([]byte, int(header.PRGNum)*(16384))
+	prgNum, chrNum := int(header.PRGNum), int(header.CHRNum)
+	if header.NES2() {
+		prgNum |= int(header.ROMNum&0x0F) << 8
+		chrNum |= int(header.ROMNum>>4) << 8
+	}
+
+	prg := make([]byte, prgNum*(16384))
 
 	if _, err := io.ReadFull(file, prg); err != nil {
 		return nil, fmt.Errorf("Error in reading PRG ROM: %v", err)
 	}
 
-	var chr []byte
 	// CHR -- 8 KB each
-	if header.CHRNum != 0 {
-		chr = make([]byte, int(header.CHRNum)*(8192))
-		if _, err := io.ReadFull(file, chr); err != nil {
-			return nil, fmt.Errorf("Error in reading CHR ROM: %v", err)
+	chr := make([]byte, chrNum*(8192))
+	if _, err := io.ReadFull(file, chr); err != nil {
+		return nil, fmt.Errorf("Error in reading CHR ROM: %v", err)
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE(prg), crc32.IEEETable, chr)
+
+	// RAM sizes. Old iNES headers don't have them: 8KB of PRG-RAM, kept by
+	// the battery if there is one, and 8KB of CHR-RAM without CHR-ROM.
+	prgRAM, prgNVRAM, chrRAM := 0x2000, 0, 0x2000
+	if battery == 1 {
+		prgRAM, prgNVRAM = 0, 0x2000
+	}
+	game, known := LookupGame(crc)
+	if header.NES2() {
+		prgRAM = ramSize(header.PRGRAMShift & 0x0F)
+		prgNVRAM = ramSize(header.PRGRAMShift >> 4)
+		chrRAM = ramSize(header.CHRRAMShift&0x0F) + ramSize(header.CHRRAMShift>>4)
+	} else if known {
+		prgRAM, prgNVRAM, chrRAM = game.PRGRAM, game.PRGNVRAM, game.CHRRAM
+	}
+	if chrNum == 0 {
+		if chrRAM < 0x2000 {
+			chrRAM = 0x2000
 		}
-	} else {
-		chr = make([]byte, 8192)
+		chr = make([]byte, chrRAM)
 	}
 
 	//Now every thing is OK, return thr cartridge
 
-	return NewCartridge(prg, chr, mapper, mirror, battery), nil
+	cartridge := NewCartridge(prg, chr, mapper, mirror, battery)
+	cartridge.Submapper = submapper
+	cartridge.CHRRAM = chrNum == 0
+	cartridge.CRC = crc
+	cartridge.SetRAM(prgRAM, prgNVRAM)
+	if known {
+		cartridge.Board = game.Board
+		// Trust a NES 2.0 header over the database, and the database over
+		// an old iNES header.
+		if !header.NES2() && game.Mapper >= 0 {
+			cartridge.Mapper = uint16(game.Mapper)
+			if game.Submapper >= 0 {
+				cartridge.Submapper = byte(game.Submapper)
+			}
+		}
+	}
+	return cartridge, nil
 }
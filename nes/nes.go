@@ -19,10 +19,29 @@ type NES struct {
 	Mapper      Mapper
 	CPUMemory   Memory
 	PPUMemory   Memory
+	NSF         *NSF // Set when playing an NSF file instead of a game
+	FDS         *FDS // Set when playing a Disk System image
+	recorders   []Recorder
 }
 
 func NewNES(path string) (*NES, error) {
-	cartidge, err := LoadNES(path)
+	var cartidge *Cartridge
+	var nsf *NSF
+	var fds *FDS
+	var err error
+	if IsNSF(path) {
+		nsf, err = LoadNSF(path)
+		if err == nil {
+			cartidge = nsf.Cartridge()
+		}
+	} else if IsFDS(path) {
+		fds, err = LoadFDS(path)
+		if err == nil {
+			cartidge = fds.Cartridge()
+		}
+	} else {
+		cartidge, err = LoadNES(path)
+	}
 
 	if err != nil {
 		return nil, err
@@ -31,16 +50,28 @@ func NewNES(path string) (*NES, error) {
 	ram := make([]byte, 2048)
 	Controller1 := NewController()
 	Controller2 := NewController()
-	nes := NES{path, nil, cartidge, Controller1, Controller2, nil, nil, ram, nil, nil, nil}
+	nes := NES{
+		FileName:    path,
+		Cartridge:   cartidge,
+		Controller1: Controller1,
+		Controller2: Controller2,
+		RAM:         ram,
+		NSF:         nsf,
+		FDS:         fds,
+	}
 	mapper, err := NewMapper(&nes)
 	if err != nil {
 		return nil, err
 	}
 	nes.Mapper = mapper
+	if err := nes.loadSave(); err != nil {
+		return nil, err
+	}
 	nes.APU = NewAPU(&nes)
 	nes.CPUMemory = NewCPUMemory(&nes)
 	nes.PPUMemory = NewPPUMemory(&nes)
 	nes.CPU = NewCPU(nes.CPUMemory)
+	nes.APU.dmc.cpu = nes.CPU
 	nes.PPU = NewPPU(&nes)
 	return &nes, nil
 }
@@ -54,6 +85,7 @@ func (nes *NES) Run() int {
 	for i := 0; i < cpuCycles*3; i++ {
 		if i < cpuCycles {
 			nes.APU.Run()
+			nes.Mapper.Tick()
 		}
 		nes.PPU.Run()
 		nes.Mapper.Run()
@@ -68,6 +100,14 @@ func (n *NES) RunSeconds(second float64) {
 	}
 }
 
+// StepFrame runs the emulator until the PPU finishes the current frame.
+func (n *NES) StepFrame() {
+	frame := n.PPU.Frame
+	for frame == n.PPU.Frame {
+		n.Run()
+	}
+}
+
 func (n *NES) Buffer() *image.RGBA {
 	return n.PPU.front
 }
@@ -89,12 +129,16 @@ func (n *NES) SetAPUSRate(sRate float64) {
 	log.Print(sRate)
 	if sRate != 0 {
 		n.APU.sampleRate = CPUFrequency / sRate
-		n.APU.fChain = FilterChain{
-			HPassFilter(float32(sRate), 90),
-			HPassFilter(float32(sRate), 440),
-			LPassFilter(float32(sRate), 14000),
-		}
+		n.APU.fChain = NewFilterChain(float32(sRate))
 	} else {
 		n.APU.fChain = nil
 	}
 }
+
+// SampleRate returns the APU output rate set by SetAPUSRate.
+func (n *NES) SampleRate() float64 {
+	if n.APU.sampleRate == 0 {
+		return 0
+	}
+	return CPUFrequency / n.APU.sampleRate
+}
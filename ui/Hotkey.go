@@ -0,0 +1,182 @@
+package ui
+
+import (
+	"fmt"
+	"github.com/go-gl/glfw/v3.2/glfw"
+	"github.com/kuso-kodo/kuso-NES/nes"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Length of the clip saved by the GIF hotkey.
+const gifSeconds = 10
+
+// Sample rate of the WAV hotkey recordings.
+const wavRate = 48000
+
+// hotkeys handles the function keys:
+// F7 ejects and inserts the disk of a Disk System game,
+// F8 puts in the next disk side,
+// F9 starts and stops AVI recording,
+// F10 saves the last seconds of gameplay as a GIF,
+// F11 starts and stops WAV recording,
+// F12 starts and stops VGM logging, with loop detection.
+// When playing an NSF file, Left and Right select the previous and next track.
+type hotkeys struct {
+	nes *nes.NES
+	avi *nes.AVIRecorder
+	gif *nes.GIFRecorder
+	wav *nes.WAVRecorder
+	vgm *nes.VGMRecorder
+}
+
+func newHotkeys(n *nes.NES) *hotkeys {
+	h := hotkeys{nes: n}
+	h.gif = nes.NewGIFRecorder("", gifSeconds, true)
+	n.AddRecorder(h.gif)
+	return &h
+}
+
+// outputName builds a file name in the working directory from the rom name,
+// so recordings of zipped roms don't land in the temporary directory.
+func outputName(n *nes.NES, ext string) string {
+	base := filepath.Base(n.FileName)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	return fmt.Sprintf("%s-%s%s", base, time.Now().Format("20060102-150405"), ext)
+}
+
+func (h *hotkeys) callback(window *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	if action != glfw.Press {
+		return
+	}
+	switch key {
+	case glfw.KeyF7:
+		h.ejectDisk()
+	case glfw.KeyF8:
+		h.nextDiskSide()
+	case glfw.KeyF9:
+		h.toggleAVI()
+	case glfw.KeyF10:
+		h.saveGIF()
+	case glfw.KeyF11:
+		h.toggleWAV()
+	case glfw.KeyF12:
+		h.toggleVGM()
+	case glfw.KeyLeft:
+		h.selectTrack(window, -1)
+	case glfw.KeyRight:
+		h.selectTrack(window, 1)
+	}
+}
+
+func (h *hotkeys) selectTrack(window *glfw.Window, step int) {
+	if h.nes.NSF == nil {
+		return
+	}
+	h.nes.SelectTrack(h.nes.Track() + step)
+	window.SetTitle(title(h.nes))
+}
+
+func (h *hotkeys) ejectDisk() {
+	if h.nes.FDS == nil {
+		return
+	}
+	h.nes.EjectDisk()
+	if _, inserted := h.nes.DiskSide(); inserted {
+		log.Print("Disk inserted.")
+	} else {
+		log.Print("Disk ejected.")
+	}
+}
+
+func (h *hotkeys) nextDiskSide() {
+	if h.nes.FDS == nil {
+		return
+	}
+	h.nes.NextDiskSide()
+	side, _ := h.nes.DiskSide()
+	log.Printf("Disk %d side %c inserted.", side/2+1, 'A'+side%2)
+}
+
+func (h *hotkeys) toggleAVI() {
+	if h.avi != nil {
+		if err := h.nes.RemoveRecorder(h.avi); err != nil {
+			log.Printf("Stop AVI recording failed: %v", err)
+		}
+		h.avi = nil
+		log.Print("AVI recording stopped.")
+		return
+	}
+	path := outputName(h.nes, ".avi")
+	avi, err := nes.NewAVIRecorder(path, int(h.nes.SampleRate()))
+	if err != nil {
+		log.Printf("Start AVI recording failed: %v", err)
+		return
+	}
+	h.avi = avi
+	h.nes.AddRecorder(avi)
+	log.Printf("AVI recording to %v.", path)
+}
+
+func (h *hotkeys) saveGIF() {
+	path := outputName(h.nes, ".gif")
+	if err := h.gif.SaveAs(path); err != nil {
+		log.Printf("Save GIF failed: %v", err)
+		return
+	}
+	log.Printf("GIF saved to %v.", path)
+}
+
+func (h *hotkeys) toggleWAV() {
+	if h.wav != nil {
+		if err := h.nes.RemoveRecorder(h.wav); err != nil {
+			log.Printf("Stop WAV recording failed: %v", err)
+		}
+		h.wav = nil
+		log.Print("WAV recording stopped.")
+		return
+	}
+	path := outputName(h.nes, ".wav")
+	wav, err := nes.NewWAVRecorder(path, wavRate, true, false)
+	if err != nil {
+		log.Printf("Start WAV recording failed: %v", err)
+		return
+	}
+	h.wav = wav
+	h.nes.AddRecorder(wav)
+	log.Printf("WAV recording to %v.", path)
+}
+
+func (h *hotkeys) toggleVGM() {
+	if h.vgm != nil {
+		if err := h.nes.RemoveRecorder(h.vgm); err != nil {
+			log.Printf("Stop VGM logging failed: %v", err)
+		}
+		h.vgm = nil
+		log.Print("VGM logging stopped.")
+		return
+	}
+	path := outputName(h.nes, ".vgm")
+	h.vgm = nes.NewVGMRecorder(path, true)
+	h.nes.AddRecorder(h.vgm)
+	log.Printf("VGM logging to %v.", path)
+}
+
+// close stops every recording still running when the window closes, and
+// saves the disk of a Disk System game.
+func (h *hotkeys) close() {
+	if err := h.nes.Save(); err != nil {
+		log.Printf("Save failed: %v", err)
+	}
+	if h.avi != nil {
+		h.toggleAVI()
+	}
+	if h.wav != nil {
+		h.toggleWAV()
+	}
+	if h.vgm != nil {
+		h.toggleVGM()
+	}
+}
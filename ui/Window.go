@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"fmt"
 	"github.com/go-gl/gl/all-core/gl"
 	"github.com/go-gl/glfw/v3.2/glfw"
 	"github.com/gordonklaus/portaudio"
@@ -38,6 +39,13 @@ func getKeys(window *glfw.Window, n *nes.NES) {
 	n.SetKeyPressed(1, nes.BRight, readKey(window, glfw.KeyD))
 }
 
+func title(n *nes.NES) string {
+	if n.NSF != nil {
+		return fmt.Sprintf("KUSO-NES - %s [%d/%d]", n.NSF.TrackTitle(n.Track()), n.Track()+1, n.NSF.Songs)
+	}
+	return "KUSO-NES - " + n.FileName
+}
+
 func Run(nes *nes.NES) {
 	portaudio.Initialize()
 	defer portaudio.Terminate()
@@ -49,7 +57,7 @@ func Run(nes *nes.NES) {
 
 	glfw.WindowHint(glfw.ContextVersionMajor, 2)
 	glfw.WindowHint(glfw.ContextVersionMinor, 1)
-	window, err := glfw.CreateWindow(Width*Scale, Height*Scale, "KUSO-NES - "+nes.FileName, nil, nil)
+	window, err := glfw.CreateWindow(Width*Scale, Height*Scale, title(nes), nil, nil)
 	if err != nil {
 		log.Panic("GLFW CreateWindow error: ", err)
 	}
@@ -79,6 +87,10 @@ func Run(nes *nes.NES) {
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.CLAMP_TO_EDGE)
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_T, gl.CLAMP_TO_EDGE)
 
+	keys := newHotkeys(nes)
+	window.SetKeyCallback(keys.callback)
+	defer keys.close()
+
 	t1 := glfw.GetTime()
 
 	var test bool